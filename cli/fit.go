@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/qeesung/image2ascii/terminal"
+	"github.com/skale-5/skalogram/render"
+)
+
+// fitTerminal bounds the rendering by the terminal, keeping a line for the
+// prompt. The renderer keeps the image aspect ratio within these bounds.
+func fitTerminal(opts *render.Options) error {
+	columns, lines, err := terminal.NewTerminalAccessor().ScreenSize()
+	if err != nil {
+		return err
+	}
+	opts.Width = clamp(columns, 1, render.MaxWidth)
+	opts.Height = clamp(lines-1, 1, render.MaxHeight)
+	return nil
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
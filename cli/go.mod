@@ -3,10 +3,9 @@ module github.com/skale-5/skalogram
 go 1.16
 
 require (
-	github.com/aybabtme/rgbterm v0.0.0-20170906152045-cc83f3b3ce59 // indirect
-	github.com/mattn/go-isatty v0.0.14 // indirect
-	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646 // indirect
 	github.com/qeesung/image2ascii v1.0.1
+	github.com/skale-5/skalogram/render v0.0.0
 	github.com/stretchr/testify v1.7.0 // indirect
-	github.com/wayneashleyberry/terminal-dimensions v1.1.0 // indirect
 )
+
+replace github.com/skale-5/skalogram/render => ../render
@@ -2,13 +2,14 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 
-	"github.com/qeesung/image2ascii/convert"
+	"github.com/skale-5/skalogram/render"
 )
 
 type Database struct {
@@ -67,31 +68,71 @@ func NewDatabase(dbPath string) *Database {
 	return db
 }
 
+// renderFlags registers the render options on fs, defaulting to the web
+// ones.
+func renderFlags(fs *flag.FlagSet) *render.Options {
+	opts := render.DefaultOptions()
+	fs.StringVar((*string)(&opts.Mode), "mode", string(opts.Mode), "render mode: ascii, halfblock or braille")
+	fs.IntVar(&opts.Width, "width", opts.Width, "maximum width in characters")
+	fs.IntVar(&opts.Height, "height", opts.Height, "maximum height in characters")
+	fs.Float64Var(&opts.Ratio, "ratio", opts.Ratio, "height to width ratio of a character")
+	fs.BoolVar(&opts.Color, "color", opts.Color, "color the characters")
+	fs.StringVar(&opts.Ramp, "ramp", opts.Ramp, "characters from the darkest to the brightest")
+	fs.BoolVar(&opts.Invert, "invert", opts.Invert, "render the negative of the image")
+	fs.BoolVar(&opts.Reversed, "reversed", opts.Reversed, "reverse the ramp, for light backgrounds")
+	fs.StringVar(&opts.Pipeline, "pipeline", opts.Pipeline, "preprocessing stages, e.g. contrast:1.5,dither:floyd-steinberg")
+	return &opts
+}
+
 func main() {
 
 	// Parse command line arguments
 	imagePath := flag.String("image", "", "path to the image you want to print (required)")
 	dbPath := flag.String("db-path", "./.db.json", "path to the skalogram database")
+	opts := renderFlags(flag.CommandLine)
+	fit := flag.Bool("fit", false, "fit the terminal instead of width and height")
+	palette := flag.String("palette", "", "colors of the terminal: truecolor, 256, 16 or mono (default detected from COLORTERM and TERM)")
 	flag.Parse()
 
 	if *imagePath == "" {
 		flag.PrintDefaults()
 		return
 	}
+	if *fit {
+		if err := fitTerminal(opts); err != nil {
+			panic(err)
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	terminalPalette := render.DetectPalette(os.Getenv)
+	if *palette != "" {
+		terminalPalette = render.Palette(*palette)
+	}
+	if !terminalPalette.Valid() {
+		fmt.Printf("unknown palette %q\n", terminalPalette)
+		os.Exit(2)
+	}
 
 	// Load database into memory
 	db := NewDatabase(*dbPath)
 
-	// Create Image Converter and open image
-	converter := convert.NewImageConverter()
-	img, err := convert.OpenImageFile(*imagePath)
+	// Render image and Print it, animations are played once
+	imageFile, err := os.Open(*imagePath)
+	if err != nil {
+		panic(err)
+	}
+	canvas, err := render.Decode(imageFile, *opts)
+	imageFile.Close()
+	if err != nil {
+		panic(err)
+	}
+	err = canvas.PlayANSI(context.Background(), os.Stdout, 1, terminalPalette)
 	if err != nil {
 		panic(err)
 	}
-
-	// Convert image to ASCII and Print it
-	ascii := converter.Image2ASCIIString(img, &convert.DefaultOptions)
-	fmt.Print(ascii)
 
 	// Ask user if he likes the image
 	scanner := bufio.NewScanner(os.Stdin)
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/skale-5/skalogram/render"
+)
+
+func TestRenderFlagsDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("skalogram", flag.ContinueOnError)
+	opts := renderFlags(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*opts, render.DefaultOptions()) {
+		t.Errorf("got %+v, want render.DefaultOptions() %+v", *opts, render.DefaultOptions())
+	}
+}
+
+func TestRenderFlags(t *testing.T) {
+	fs := flag.NewFlagSet("skalogram", flag.ContinueOnError)
+	opts := renderFlags(fs)
+	if err := fs.Parse([]string{"-mode", "braille", "-width", "80", "-color=false"}); err != nil {
+		t.Fatal(err)
+	}
+	want := render.DefaultOptions()
+	want.Mode = render.ModeBraille
+	want.Width = 80
+	want.Color = false
+	if !reflect.DeepEqual(*opts, want) {
+		t.Errorf("got %+v, want %+v", *opts, want)
+	}
+}
@@ -0,0 +1,156 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+)
+
+// MaxFrames bounds the frames of an animation, longer ones are sampled.
+const MaxFrames = 50
+
+// MaxAnimationCells bounds the cells of all the frames of an animation, so
+// that larger renderings are sampled down to fewer frames.
+const MaxAnimationCells = 60_000
+
+// minFrameDelay is the shortest delay honoured, shorter ones are shown for
+// defaultFrameDelay as browsers do. Longer delays than maxFrameDelay are
+// shortened to it.
+const (
+	minFrameDelay     = 20 * time.Millisecond
+	defaultFrameDelay = 100 * time.Millisecond
+	maxFrameDelay     = 5 * time.Second
+)
+
+// RenderGIF draws every frame of an animated GIF, composed over the previous
+// ones according to their disposal method. GIFs of a single frame are still
+// images. Screens larger than MaxPixels are rejected.
+func RenderGIF(g *gif.GIF, o Options) (*Canvas, error) {
+	if len(g.Image) == 0 {
+		return nil, errors.New("gif has no frame")
+	}
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+	if err := CheckSize(bounds.Dx(), bounds.Dy()); err != nil {
+		return nil, err
+	}
+	screen := image.NewRGBA(bounds)
+	width, height := cells(screen, o)
+	maxFrames := MaxAnimationCells / (width * height)
+	if maxFrames > MaxFrames {
+		maxFrames = MaxFrames
+	}
+	if maxFrames < 1 {
+		maxFrames = 1
+	}
+	step := (len(g.Image) + maxFrames - 1) / maxFrames
+
+	var canvas *Canvas
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(screen.Bounds())
+			draw.Draw(previous, previous.Bounds(), screen, screen.Bounds().Min, draw.Src)
+		}
+		draw.Draw(screen, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		delay := time.Duration(g.Delay[i]) * 10 * time.Millisecond
+		if delay < minFrameDelay {
+			delay = defaultFrameDelay
+		}
+		if i%step == 0 {
+			rendered := Render(screen, o)
+			if canvas == nil {
+				canvas = rendered
+			}
+			canvas.Frames = append(canvas.Frames, Frame{Rows: rendered.Rows})
+		}
+		// sampled out frames extend the delay of the last rendered one
+		last := &canvas.Frames[len(canvas.Frames)-1]
+		last.Delay += delay
+		if last.Delay > maxFrameDelay {
+			last.Delay = maxFrameDelay
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(screen, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			screen = previous
+		}
+	}
+	if !canvas.Animated() {
+		canvas.Frames = nil
+	}
+	return canvas, nil
+}
+
+// PlayANSI writes the frames of an animation in palette p loops times, each
+// one over the previous one after its delay, until ctx is done. Still
+// canvases are written once.
+func (c *Canvas) PlayANSI(ctx context.Context, w io.Writer, loops int, p Palette) error {
+	if !c.Animated() {
+		_, err := io.WriteString(w, c.ANSIPalette(p))
+		return err
+	}
+	flusher, _ := w.(interface{ Flush() })
+
+	// hide the cursor while playing
+	if _, err := io.WriteString(w, "\x1b[?25l"); err != nil {
+		return err
+	}
+	defer io.WriteString(w, "\x1b[?25h")
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for loop := 0; loop < loops; loop++ {
+		for i, frame := range c.Frames {
+			if loop > 0 || i > 0 {
+				// move back to the top of the previous frame
+				if _, err := fmt.Fprintf(w, "\x1b[%dA", len(frame.Rows)); err != nil {
+					return err
+				}
+			}
+			if _, err := io.WriteString(w, c.ansiRows(frame.Rows, p)); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+
+			timer.Reset(frame.Delay)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	return nil
+}
+
+// AnimationScript plays in the browser the HTML of animated canvases,
+// showing one frame at a time.
+const AnimationScript = `document.querySelectorAll("[data-frames]:not([data-playing])").forEach(function (player) {
+	player.dataset.playing = "";
+	var frames = player.children, i = 0;
+	function next() {
+		frames[i].hidden = true;
+		i = (i + 1) % frames.length;
+		frames[i].hidden = false;
+		setTimeout(next, frames[i].dataset.delay);
+	}
+	setTimeout(next, frames[0].dataset.delay);
+});`
@@ -0,0 +1,110 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/qeesung/image2ascii/convert"
+)
+
+// Render draws img in the mode of o.
+func Render(img image.Image, o Options) *Canvas {
+	switch o.Mode {
+	case ModeHalfBlock:
+		return renderHalfBlock(img, o)
+	case ModeBraille:
+		return renderBraille(img, o)
+	}
+	return renderAscii(img, o)
+}
+
+// cells is the size in characters of the rendering of img, the largest
+// fitting o.Width x o.Height which keeps the aspect ratio of img, with
+// characters o.Ratio times as high as they are wide.
+func cells(img image.Image, o Options) (width, height int) {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return o.Width, o.Height
+	}
+	aspect := float64(bounds.Dy()) / float64(bounds.Dx()) / o.Ratio
+	width, height = o.Width, int(math.Round(float64(o.Width)*aspect))
+	if height > o.Height {
+		width, height = int(math.Round(float64(o.Height)/aspect)), o.Height
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return width, height
+}
+
+// pixels scales img to width x height and returns its pixels row by row,
+// preprocessed by the pipeline for a mode drawing levels intensities.
+func pixels(img image.Image, width, height, levels int, o Options) [][]color.NRGBA {
+	scaled := convert.NewResizeHandler().ScaleImage(img, &convert.Options{
+		FixedWidth:  width,
+		FixedHeight: height,
+	})
+
+	bounds := scaled.Bounds()
+	rows := make([][]color.NRGBA, 0, bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := make([]color.NRGBA, 0, bounds.Dx())
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(scaled.At(x, y)).(color.NRGBA)
+			if o.Invert {
+				c.R, c.G, c.B = 255-c.R, 255-c.G, 255-c.B
+			}
+			row = append(row, c)
+		}
+		rows = append(rows, row)
+	}
+
+	// options are validated beforehand
+	stages, _ := ParsePipeline(o.Pipeline)
+	for _, stage := range stages {
+		stage.Apply(rows, levels)
+	}
+	return rows
+}
+
+// renderAscii draws img with the characters of the ramp.
+func renderAscii(img image.Image, o Options) *Canvas {
+	ramp := []rune(o.Ramp)
+	if o.Reversed {
+		for i, j := 0, len(ramp)-1; i < j; i, j = i+1, j-1 {
+			ramp[i], ramp[j] = ramp[j], ramp[i]
+		}
+	}
+
+	width, height := cells(img, o)
+	rows := pixels(img, width, height, len(ramp), o)
+	canvas := &Canvas{
+		Rows:    make([][]Cell, 0, len(rows)),
+		Colored: o.Color,
+	}
+	for _, pixelRow := range rows {
+		row := make([]Cell, 0, len(pixelRow))
+		for _, c := range pixelRow {
+			row = append(row, Cell{
+				Char:  ramp[rampIndex(c, len(ramp))],
+				Color: c,
+			})
+		}
+		canvas.Rows = append(canvas.Rows, row)
+	}
+	return canvas
+}
+
+// intensity of c weighted by its alpha, between 0 and 1.
+func intensity(c color.NRGBA) float64 {
+	return float64(int(c.R)+int(c.G)+int(c.B)) * float64(c.A) / (3 * 255 * 255)
+}
+
+// rampIndex maps the intensity of c to one of n characters.
+func rampIndex(c color.NRGBA, n int) int {
+	return int(math.Round(intensity(c) * float64(n-1)))
+}
@@ -0,0 +1,142 @@
+package render
+
+import (
+	"image"
+	"image/color"
+)
+
+const (
+	upperHalfBlock = '▀'
+	lowerHalfBlock = '▄'
+	fullBlock      = '█'
+	brailleBlank   = '⠀'
+)
+
+// brailleDots are the bits of the Braille dots, indexed by row then column
+// of a 2x4 cell.
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// renderHalfBlock draws 2 pixels per cell: the top one as the foreground of
+// an upper half block and the bottom one as its background. Colorless
+// canvases light the halves brighter than the mean instead.
+func renderHalfBlock(img image.Image, o Options) *Canvas {
+	width, height := cells(img, o)
+	rows := pixels(img, width, 2*height, 2, o)
+	lit := litPixels(rows, o.Reversed)
+	canvas := &Canvas{
+		Rows:    make([][]Cell, 0, height),
+		Colored: o.Color,
+	}
+	for y := 0; y+1 < len(rows); y += 2 {
+		row := make([]Cell, 0, len(rows[y]))
+		for x := range rows[y] {
+			top, bottom := rows[y][x], rows[y+1][x]
+			if o.Color {
+				row = append(row, Cell{Char: upperHalfBlock, Color: top, Background: bottom})
+				continue
+			}
+			char := ' '
+			switch {
+			case lit[y][x] && lit[y+1][x]:
+				char = fullBlock
+			case lit[y][x]:
+				char = upperHalfBlock
+			case lit[y+1][x]:
+				char = lowerHalfBlock
+			}
+			row = append(row, Cell{Char: char, Color: top})
+		}
+		canvas.Rows = append(canvas.Rows, row)
+	}
+	return canvas
+}
+
+// renderBraille draws 8 dots per cell, the pixels brighter than the mean
+// being raised. Cells are colored with the average of their raised dots.
+func renderBraille(img image.Image, o Options) *Canvas {
+	width, height := cells(img, o)
+	rows := pixels(img, 2*width, 4*height, 2, o)
+	lit := litPixels(rows, o.Reversed)
+	canvas := &Canvas{
+		Rows:    make([][]Cell, 0, height),
+		Colored: o.Color,
+	}
+	for y := 0; y+3 < len(rows); y += 4 {
+		row := make([]Cell, 0, len(rows[y])/2)
+		for x := 0; x+1 < len(rows[y]); x += 2 {
+			char := brailleBlank
+			var all, raised colorSum
+			for dy := 0; dy < 4; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					c := rows[y+dy][x+dx]
+					all.add(c)
+					if lit[y+dy][x+dx] {
+						char += brailleDots[dy][dx]
+						raised.add(c)
+					}
+				}
+			}
+			c := raised.average()
+			if raised.n == 0 {
+				c = all.average()
+			}
+			row = append(row, Cell{Char: char, Color: c})
+		}
+		canvas.Rows = append(canvas.Rows, row)
+	}
+	return canvas
+}
+
+// litPixels thresholds the pixels on their mean intensity, reversed lights
+// the dark ones.
+func litPixels(rows [][]color.NRGBA, reversed bool) [][]bool {
+	var sum float64
+	var n int
+	for _, row := range rows {
+		for _, c := range row {
+			sum += intensity(c)
+			n++
+		}
+	}
+	mean := 0.5
+	if n > 0 {
+		mean = sum / float64(n)
+	}
+	lit := make([][]bool, len(rows))
+	for y, row := range rows {
+		lit[y] = make([]bool, len(row))
+		for x, c := range row {
+			lit[y][x] = (intensity(c) > mean) != reversed
+		}
+	}
+	return lit
+}
+
+type colorSum struct {
+	r, g, b, a, n int
+}
+
+func (s *colorSum) add(c color.NRGBA) {
+	s.r += int(c.R)
+	s.g += int(c.G)
+	s.b += int(c.B)
+	s.a += int(c.A)
+	s.n++
+}
+
+func (s colorSum) average() color.NRGBA {
+	if s.n == 0 {
+		return color.NRGBA{}
+	}
+	return color.NRGBA{
+		R: uint8(s.r / s.n),
+		G: uint8(s.g / s.n),
+		B: uint8(s.b / s.n),
+		A: uint8(s.a / s.n),
+	}
+}
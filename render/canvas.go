@@ -0,0 +1,182 @@
+package render
+
+import (
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"time"
+)
+
+// Cell is a character of a rendering with its colors.
+type Cell struct {
+	Char  rune
+	Color color.NRGBA
+	// Background is only drawn when it is not fully transparent.
+	Background color.NRGBA
+}
+
+// Frame is an image of an animation, shown for Delay.
+type Frame struct {
+	Rows  [][]Cell
+	Delay time.Duration
+}
+
+// Canvas is a rendering, independent of its output format.
+type Canvas struct {
+	// Rows are the first frame of animations, still output formats only
+	// draw them.
+	Rows [][]Cell
+	// Colored canvases are output with the color of their cells.
+	Colored bool
+	// Frames of animations, the first one sharing Rows, nil for still
+	// images.
+	Frames []Frame
+}
+
+func (c *Canvas) Animated() bool {
+	return len(c.Frames) > 1
+}
+
+var ErrMalformedCanvas = errors.New("malformed canvas")
+
+// maxCanvasSide guards the decoding against absurd sizes.
+const maxCanvasSide = 1 << 12
+
+// cellSize is the encoded size of a cell: its rune, color and background.
+const cellSize = 12
+
+const (
+	flagColored = 1 << iota
+	flagAnimated
+)
+
+func appendUint32(data []byte, v uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return append(data, b[:]...)
+}
+
+func appendCells(data []byte, rows [][]Cell, width int) ([]byte, error) {
+	for _, row := range rows {
+		if len(row) != width {
+			return nil, ErrMalformedCanvas
+		}
+		for _, cell := range row {
+			data = appendUint32(data, uint32(cell.Char))
+			data = append(data, cell.Color.R, cell.Color.G, cell.Color.B, cell.Color.A)
+			data = append(data, cell.Background.R, cell.Background.G, cell.Background.B, cell.Background.A)
+		}
+	}
+	return data, nil
+}
+
+func readCells(data []byte, width, height int) ([][]Cell, []byte, error) {
+	if len(data) < cellSize*width*height {
+		return nil, nil, ErrMalformedCanvas
+	}
+	rows := make([][]Cell, height)
+	for y := range rows {
+		rows[y] = make([]Cell, width)
+		for x := range rows[y] {
+			rows[y][x] = Cell{
+				Char:       rune(binary.BigEndian.Uint32(data)),
+				Color:      color.NRGBA{R: data[4], G: data[5], B: data[6], A: data[7]},
+				Background: color.NRGBA{R: data[8], G: data[9], B: data[10], A: data[11]},
+			}
+			data = data[cellSize:]
+		}
+	}
+	return rows, data, nil
+}
+
+// MarshalBinary encodes the canvas as a header of its flags, width and
+// height, followed by 12 bytes per cell. Animations go on with their frame
+// count, the delay of every frame in milliseconds and the cells of the
+// frames following the first one.
+func (c *Canvas) MarshalBinary() ([]byte, error) {
+	height := len(c.Rows)
+	width := 0
+	if height > 0 {
+		width = len(c.Rows[0])
+	}
+	frames := 1
+	if c.Animated() {
+		frames = len(c.Frames)
+	}
+	data := make([]byte, 0, 13+4*frames+cellSize*width*height*frames)
+	var flags byte
+	if c.Colored {
+		flags |= flagColored
+	}
+	if c.Animated() {
+		flags |= flagAnimated
+	}
+	data = append(data, flags)
+	data = appendUint32(data, uint32(width))
+	data = appendUint32(data, uint32(height))
+	data, err := appendCells(data, c.Rows, width)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Animated() {
+		return data, nil
+	}
+
+	data = appendUint32(data, uint32(len(c.Frames)))
+	for _, frame := range c.Frames {
+		data = appendUint32(data, uint32(frame.Delay.Milliseconds()))
+	}
+	for _, frame := range c.Frames[1:] {
+		if len(frame.Rows) != height {
+			return nil, ErrMalformedCanvas
+		}
+		if data, err = appendCells(data, frame.Rows, width); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (c *Canvas) UnmarshalBinary(data []byte) error {
+	if len(data) < 9 {
+		return ErrMalformedCanvas
+	}
+	flags := data[0]
+	width := int(binary.BigEndian.Uint32(data[1:5]))
+	height := int(binary.BigEndian.Uint32(data[5:9]))
+	if width > maxCanvasSide || height > maxCanvasSide {
+		return ErrMalformedCanvas
+	}
+	rows, data, err := readCells(data[9:], width, height)
+	if err != nil {
+		return err
+	}
+	c.Colored = flags&flagColored != 0
+	c.Rows = rows
+	c.Frames = nil
+	if flags&flagAnimated == 0 {
+		if len(data) != 0 {
+			return ErrMalformedCanvas
+		}
+		return nil
+	}
+
+	if len(data) < 4 {
+		return ErrMalformedCanvas
+	}
+	count := int(binary.BigEndian.Uint32(data))
+	data = data[4:]
+	if count < 2 || count > MaxFrames || len(data) != 4*count+cellSize*width*height*(count-1) {
+		return ErrMalformedCanvas
+	}
+	c.Frames = make([]Frame, count)
+	for i := range c.Frames {
+		c.Frames[i].Delay = time.Duration(binary.BigEndian.Uint32(data)) * time.Millisecond
+		data = data[4:]
+	}
+	c.Frames[0].Rows = rows
+	for i := 1; i < count; i++ {
+		c.Frames[i].Rows, data, _ = readCells(data, width, height)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package render
+
+import (
+	"errors"
+	"image/color"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCanvasBinaryRoundTrip(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	frame := [][]Cell{{{Char: '@', Color: red}, {Char: ' '}}}
+	canvases := map[string]*Canvas{
+		"still": {
+			Rows:    [][]Cell{{{Char: '@', Color: red}, {Char: '⣿', Background: red}}},
+			Colored: true,
+		},
+		"animated": {
+			Rows: frame,
+			Frames: []Frame{
+				{Rows: frame, Delay: 100 * time.Millisecond},
+				{Rows: [][]Cell{{{Char: ' '}, {Char: '@'}}}, Delay: time.Second},
+			},
+		},
+	}
+	for name, canvas := range canvases {
+		t.Run(name, func(t *testing.T) {
+			data, err := canvas.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := &Canvas{}
+			if err := got.UnmarshalBinary(data); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, canvas) {
+				t.Errorf("got %+v, want %+v", got, canvas)
+			}
+		})
+	}
+}
+
+func TestCanvasUnmarshalMalformed(t *testing.T) {
+	data, err := (&Canvas{Rows: [][]Cell{{{Char: '@'}}}}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, malformed := range [][]byte{nil, data[:len(data)-1], append(data, 0)} {
+		if err := (&Canvas{}).UnmarshalBinary(malformed); !errors.Is(err, ErrMalformedCanvas) {
+			t.Errorf("got %v for %d bytes, want ErrMalformedCanvas", err, len(malformed))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxPixels bounds the size of the images, and of the screen of animations,
+// so that a small file declaring a huge image is not decoded.
+const MaxPixels = 40_000_000
+
+// maxAnimationPixels bounds the sum of the sizes of the frames of an
+// animation, each of them being decoded.
+const maxAnimationPixels = 2 * MaxPixels
+
+var ErrImageTooLarge = errors.New("image too large")
+
+// CheckSize rejects images larger than MaxPixels.
+func CheckSize(width, height int) error {
+	if int64(width)*int64(height) > MaxPixels {
+		return fmt.Errorf("%w: %dx%d pixels", ErrImageTooLarge, width, height)
+	}
+	return nil
+}
+
+// Decode renders an image in any of the PNG, JPEG, GIF, WebP, BMP and TIFF
+// formats, every frame of animated GIFs.
+func Decode(r io.Reader, o Options) (*Canvas, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckSize(config.Width, config.Height); err != nil {
+		return nil, err
+	}
+	if format == "gif" {
+		if pixels := gifFramePixels(data); pixels > maxAnimationPixels {
+			return nil, fmt.Errorf("%w: frames of %d pixels", ErrImageTooLarge, pixels)
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return RenderGIF(g, o)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return Render(img, o), nil
+}
+
+// gifFramePixels sums the sizes of the frames of a GIF read from their
+// descriptors, without decoding them. Malformed GIFs are left to the
+// decoder.
+func gifFramePixels(data []byte) int64 {
+	// header and logical screen descriptor
+	i := 13
+	if i > len(data) {
+		return 0
+	}
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+	var total int64
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension: a label and data sub-blocks
+			i = skipSubBlocks(data, i+2)
+		case 0x2c: // image descriptor, local color table and image data
+			if i+10 > len(data) {
+				return total
+			}
+			width := int64(data[i+5]) | int64(data[i+6])<<8
+			height := int64(data[i+7]) | int64(data[i+8])<<8
+			total += width * height
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			// skip the LZW minimum code size
+			i = skipSubBlocks(data, i+1)
+		default: // trailer
+			return total
+		}
+	}
+	return total
+}
+
+func skipSubBlocks(data []byte, i int) int {
+	for i < len(data) {
+		n := int(data[i])
+		i += n + 1
+		if n == 0 {
+			break
+		}
+	}
+	return i
+}
@@ -0,0 +1,88 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+)
+
+func TestCheckSize(t *testing.T) {
+	if err := CheckSize(8000, 5000); err != nil {
+		t.Errorf("got %s, want 40 MP accepted", err)
+	}
+	if err := CheckSize(8001, 5000); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("got %v, want ErrImageTooLarge", err)
+	}
+	if err := CheckSize(1<<31-1, 1<<31-1); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("got %v, want ErrImageTooLarge", err)
+	}
+}
+
+func encodeGIF(t *testing.T, g *gif.GIF) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeRejectsLargeGIFScreen(t *testing.T) {
+	data := encodeGIF(t, &gif.GIF{
+		Image:  []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9)},
+		Delay:  []int{0},
+		Config: image.Config{Width: 65535, Height: 65535, ColorModel: color.Palette(palette.Plan9)},
+	})
+	if _, err := Decode(bytes.NewReader(data), DefaultOptions()); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("got %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestDecodeGIFFrames(t *testing.T) {
+	frames := MaxFrames * 2
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9))
+		g.Delay = append(g.Delay, 1000)
+	}
+	canvas, err := Decode(bytes.NewReader(encodeGIF(t, g)), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(canvas.Frames) != MaxFrames {
+		t.Errorf("got %d frames, want %d", len(canvas.Frames), MaxFrames)
+	}
+	for i, frame := range canvas.Frames {
+		if frame.Delay != maxFrameDelay {
+			t.Fatalf("frame %d lasts %s, want %s", i, frame.Delay, maxFrameDelay)
+		}
+	}
+
+	o := DefaultOptions()
+	o.Width, o.Height = MaxWidth, MaxHeight
+	g.Config = image.Config{Width: 400, Height: 400, ColorModel: color.Palette(palette.Plan9)}
+	canvas, err = Decode(bytes.NewReader(encodeGIF(t, g)), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells := len(canvas.Rows) * len(canvas.Rows[0]) * len(canvas.Frames)
+	if cells > MaxAnimationCells {
+		t.Errorf("got %d cells, want at most %d", cells, MaxAnimationCells)
+	}
+}
+
+func TestPlayANSIStill(t *testing.T) {
+	canvas := &Canvas{Rows: [][]Cell{{{Char: '@'}}}}
+	var buf bytes.Buffer
+	if err := canvas.PlayANSI(context.Background(), &buf, 1, PaletteMono); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != canvas.ANSIPalette(PaletteMono) {
+		t.Errorf("got %q, want the still ANSI output", buf.String())
+	}
+}
@@ -0,0 +1,329 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+
+	"github.com/robert-nix/ansihtml"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatANSI Format = "ansi"
+	FormatHTML Format = "html"
+	FormatSVG  Format = "svg"
+	FormatPNG  Format = "png"
+)
+
+var Formats = []Format{FormatText, FormatANSI, FormatHTML, FormatSVG, FormatPNG}
+
+var formatExtensions = map[Format]string{
+	FormatText: "txt",
+	FormatANSI: "ansi",
+	FormatHTML: "html",
+	FormatSVG:  "svg",
+	FormatPNG:  "png",
+}
+
+var formatContentTypes = map[Format]string{
+	FormatText: "text/plain; charset=utf-8",
+	FormatANSI: "text/x-ansi; charset=utf-8",
+	FormatHTML: "text/html; charset=utf-8",
+	FormatSVG:  "image/svg+xml",
+	FormatPNG:  "image/png",
+}
+
+func (f Format) Extension() string {
+	return formatExtensions[f]
+}
+
+func (f Format) ContentType() string {
+	return formatContentTypes[f]
+}
+
+// MediaType is the content type without parameters.
+func (f Format) MediaType() string {
+	mediaType, _, _ := strings.Cut(f.ContentType(), ";")
+	return mediaType
+}
+
+func FormatFromExtension(ext string) (Format, bool) {
+	for f, e := range formatExtensions {
+		if e == ext {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// Encode outputs the canvas in format f. Only HTML plays animations, the
+// other formats draw their first frame, see PlayANSI for the terminal.
+func (c *Canvas) Encode(f Format) ([]byte, error) {
+	switch f {
+	case FormatText:
+		return []byte(c.Text()), nil
+	case FormatANSI:
+		return []byte(c.ANSI()), nil
+	case FormatHTML:
+		if c.Animated() {
+			return []byte(c.HTML() + "<script>" + AnimationScript + "</script>"), nil
+		}
+		return []byte(c.HTML()), nil
+	case FormatSVG:
+		return []byte(c.SVG()), nil
+	case FormatPNG:
+		return c.PNG()
+	}
+	return nil, fmt.Errorf("unknown render format %q", f)
+}
+
+// Text outputs the characters of the first frame.
+func (c *Canvas) Text() string {
+	return textRows(c.Rows)
+}
+
+func textRows(rows [][]Cell) string {
+	var sb strings.Builder
+	for _, row := range rows {
+		for _, cell := range row {
+			sb.WriteRune(cell.Char)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// ANSI colors the first frame with the 256 colors most terminals support.
+func (c *Canvas) ANSI() string {
+	return c.ANSIPalette(Palette256)
+}
+
+// ANSIPalette colors the first frame with escape codes of the nearest
+// colors of p. Colorless canvases and PaletteMono are output as Text, but
+// for the cells with a background drawn as blocks.
+func (c *Canvas) ANSIPalette(p Palette) string {
+	return c.ansiRows(c.Rows, p)
+}
+
+func (c *Canvas) ansiRows(rows [][]Cell, p Palette) string {
+	if !c.Colored {
+		return textRows(rows)
+	}
+	var sb strings.Builder
+	for _, row := range rows {
+		// escape codes are only written when the colors change
+		var fg, bg string
+		for _, cell := range row {
+			if p == PaletteMono {
+				if cell.Background.A != 0 {
+					sb.WriteRune(monoBlock(cell))
+				} else {
+					sb.WriteRune(cell.Char)
+				}
+				continue
+			}
+			cellFg, cellBg := p.sgr(cell.Color, false), ""
+			if cell.Background.A != 0 {
+				cellBg = p.sgr(cell.Background, true)
+			}
+			switch {
+			case cellBg == "" && bg != "":
+				// reset the background of the previous cell
+				fmt.Fprintf(&sb, "\x1b[0;%sm", cellFg)
+			case cellBg != bg:
+				fmt.Fprintf(&sb, "\x1b[%s;%sm", cellFg, cellBg)
+			case cellFg != fg:
+				fmt.Fprintf(&sb, "\x1b[%sm", cellFg)
+			}
+			fg, bg = cellFg, cellBg
+			sb.WriteRune(cell.Char)
+		}
+		if fg != "" {
+			sb.WriteString("\x1b[0m")
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// HTML outputs the first frame in truecolor, or every frame of animations
+// in a data-frames element for AnimationScript to play.
+func (c *Canvas) HTML() string {
+	if !c.Animated() {
+		return string(ansihtml.ConvertToHTML([]byte(c.ANSIPalette(PaletteTrueColor))))
+	}
+	var sb strings.Builder
+	sb.WriteString(`<span data-frames>`)
+	for i, frame := range c.Frames {
+		hidden := ""
+		if i > 0 {
+			hidden = " hidden"
+		}
+		fmt.Fprintf(&sb, `<span data-delay="%d"%s>`, frame.Delay.Milliseconds(), hidden)
+		sb.Write(ansihtml.ConvertToHTML([]byte(c.ansiRows(frame.Rows, PaletteTrueColor))))
+		sb.WriteString(`</span>`)
+	}
+	sb.WriteString(`</span>`)
+	return sb.String()
+}
+
+const (
+	svgCellWidth  = 7
+	svgCellHeight = 13
+)
+
+var (
+	canvasBackground = color.NRGBA{A: 255}
+	canvasForeground = color.NRGBA{R: 204, G: 204, B: 204, A: 255}
+)
+
+func (c *Canvas) cellColor(cell Cell) color.NRGBA {
+	if !c.Colored {
+		return canvasForeground
+	}
+	return cell.Color
+}
+
+func (c *Canvas) size() (int, int) {
+	if len(c.Rows) == 0 {
+		return 0, 0
+	}
+	return len(c.Rows[0]), len(c.Rows)
+}
+
+// cellRect is the area of the cell at x, y.
+func cellRect(x, y int) image.Rectangle {
+	return image.Rect(x*svgCellWidth, y*svgCellHeight, (x+1)*svgCellWidth, (y+1)*svgCellHeight)
+}
+
+// glyphShapes returns the rectangles drawing the block and Braille
+// characters in r, fonts lacking them or leaving gaps between the cells.
+func glyphShapes(char rune, r image.Rectangle) ([]image.Rectangle, bool) {
+	half := r.Min.Y + r.Dy()/2
+	switch {
+	case char == upperHalfBlock:
+		return []image.Rectangle{image.Rect(r.Min.X, r.Min.Y, r.Max.X, half)}, true
+	case char == lowerHalfBlock:
+		return []image.Rectangle{image.Rect(r.Min.X, half, r.Max.X, r.Max.Y)}, true
+	case char == fullBlock:
+		return []image.Rectangle{r}, true
+	case char >= brailleBlank && char <= brailleBlank+0xff:
+		dotWidth, dotHeight := r.Dx()/2, r.Dy()/4
+		var dots []image.Rectangle
+		for row, bits := range brailleDots {
+			for col, bit := range bits {
+				if (char-brailleBlank)&bit == 0 {
+					continue
+				}
+				x, y := r.Min.X+col*dotWidth+1, r.Min.Y+row*dotHeight+1
+				dots = append(dots, image.Rect(x, y, x+dotWidth-1, y+dotHeight-1))
+			}
+		}
+		return dots, true
+	}
+	return nil, false
+}
+
+// SVG draws the characters in a monospace font, consecutive cells of the
+// same color sharing a tspan. Backgrounds, blocks and Braille patterns are
+// drawn as rectangles.
+func (c *Canvas) SVG() string {
+	width, height := c.size()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		width*svgCellWidth, height*svgCellHeight, width*svgCellWidth, height*svgCellHeight)
+	fmt.Fprintf(&sb, `<rect width="100%%" height="100%%" fill="%s"/>`, hexColor(canvasBackground))
+	writeRect := func(r image.Rectangle, fill color.NRGBA) {
+		fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`,
+			r.Min.X, r.Min.Y, r.Dx(), r.Dy(), hexColor(fill))
+	}
+	// text holds whether a character is left to draw in the font
+	text := false
+	for y, row := range c.Rows {
+		for x, cell := range row {
+			if c.Colored && cell.Background.A != 0 {
+				writeRect(cellRect(x, y), cell.Background)
+			}
+			shapes, ok := glyphShapes(cell.Char, cellRect(x, y))
+			for _, r := range shapes {
+				writeRect(r, c.cellColor(cell))
+			}
+			text = text || (!ok && cell.Char != ' ')
+		}
+	}
+	if !text {
+		sb.WriteString(`</svg>`)
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, `<g font-family="monospace" font-size="%d" xml:space="preserve">`, svgCellHeight-1)
+	for y, row := range c.Rows {
+		fmt.Fprintf(&sb, `<text x="0" y="%d" textLength="%d">`, (y+1)*svgCellHeight-3, width*svgCellWidth)
+		for start := 0; start < len(row); {
+			fill := c.cellColor(row[start])
+			end := start
+			var run strings.Builder
+			for end < len(row) && c.cellColor(row[end]) == fill {
+				if _, ok := glyphShapes(row[end].Char, image.Rectangle{}); ok {
+					run.WriteByte(' ')
+				} else {
+					run.WriteRune(row[end].Char)
+				}
+				end++
+			}
+			fmt.Fprintf(&sb, `<tspan fill="%s">%s</tspan>`, hexColor(fill), html.EscapeString(run.String()))
+			start = end
+		}
+		sb.WriteString(`</text>`)
+	}
+	sb.WriteString(`</g></svg>`)
+	return sb.String()
+}
+
+func hexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// PNG rasterizes the characters with a 7x13 bitmap font, blocks and Braille
+// patterns with rectangles.
+func (c *Canvas) PNG() ([]byte, error) {
+	face := basicfont.Face7x13
+	width, height := c.size()
+	img := image.NewNRGBA(image.Rect(0, 0, width*face.Advance, height*face.Height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(canvasBackground), image.Point{}, draw.Src)
+
+	drawer := font.Drawer{Dst: img, Face: face}
+	for y, row := range c.Rows {
+		for x, cell := range row {
+			fill := image.NewUniform(c.cellColor(cell))
+			if c.Colored && cell.Background.A != 0 {
+				draw.Draw(img, cellRect(x, y), image.NewUniform(cell.Background), image.Point{}, draw.Src)
+			}
+			if shapes, ok := glyphShapes(cell.Char, cellRect(x, y)); ok {
+				for _, r := range shapes {
+					draw.Draw(img, r, fill, image.Point{}, draw.Src)
+				}
+				continue
+			}
+			drawer.Src = fill
+			drawer.Dot = fixed.P(x*face.Advance, y*face.Height+face.Ascent)
+			drawer.DrawString(string(cell.Char))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,17 @@
+module github.com/skale-5/skalogram/render
+
+go 1.18
+
+require (
+	github.com/qeesung/image2ascii v1.0.1
+	github.com/robert-nix/ansihtml v1.0.0
+	golang.org/x/image v0.5.0
+)
+
+require (
+	github.com/aybabtme/rgbterm v0.0.0-20170906152045-cc83f3b3ce59 // indirect
+	github.com/mattn/go-isatty v0.0.14 // indirect
+	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646 // indirect
+	github.com/wayneashleyberry/terminal-dimensions v1.1.0 // indirect
+	golang.org/x/sys v0.1.0 // indirect
+)
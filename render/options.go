@@ -0,0 +1,109 @@
+// Package render turns images into text art, for the CLI and the web
+// application alike.
+package render
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+const (
+	DefaultRamp = " .,:;i1tfLCG08@"
+
+	MaxWidth    = 200
+	MaxHeight   = 100
+	MinRatio    = 0.25
+	MaxRatio    = 4.0
+	MinRampSize = 2
+	MaxRampSize = 64
+)
+
+var ErrInvalidOptions = errors.New("invalid render options")
+
+type Mode string
+
+const (
+	// ModeAscii draws a character of the ramp per pixel.
+	ModeAscii Mode = "ascii"
+	// ModeHalfBlock draws 2 pixels per character with upper half blocks.
+	ModeHalfBlock Mode = "halfblock"
+	// ModeBraille draws 8 dots per character with Braille patterns.
+	ModeBraille Mode = "braille"
+)
+
+var Modes = []Mode{ModeAscii, ModeHalfBlock, ModeBraille}
+
+func (m Mode) Valid() bool {
+	for _, mode := range Modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+type Options struct {
+	Mode Mode
+	// Width and Height bound the rendering in characters, the image keeps
+	// its aspect ratio.
+	Width  int
+	Height int
+	// Ratio is the height to width ratio of a character cell.
+	Ratio float64
+	Color bool
+	// Ramp lists the characters from the darkest to the brightest, only
+	// ModeAscii uses it.
+	Ramp string
+	// Invert renders the negative of the image.
+	Invert bool
+	// Reversed walks the ramp from the brightest to the darkest, or draws
+	// the dark pixels with the other modes, for light backgrounds.
+	Reversed bool
+	// Pipeline lists the preprocessing stages, see ParsePipeline.
+	Pipeline string
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Mode:   ModeAscii,
+		Width:  55,
+		Height: 20,
+		Ratio:  2,
+		Color:  true,
+		Ramp:   DefaultRamp,
+	}
+}
+
+func (o Options) Validate() error {
+	if !o.Mode.Valid() {
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidOptions, o.Mode)
+	}
+	if o.Width < 1 || o.Width > MaxWidth {
+		return fmt.Errorf("%w: width must be between 1 and %d", ErrInvalidOptions, MaxWidth)
+	}
+	if o.Height < 1 || o.Height > MaxHeight {
+		return fmt.Errorf("%w: height must be between 1 and %d", ErrInvalidOptions, MaxHeight)
+	}
+	if !(o.Ratio >= MinRatio && o.Ratio <= MaxRatio) {
+		return fmt.Errorf("%w: ratio must be between %g and %g", ErrInvalidOptions, MinRatio, MaxRatio)
+	}
+	if len(o.Ramp) < MinRampSize || len(o.Ramp) > MaxRampSize {
+		return fmt.Errorf("%w: ramp must have between %d and %d characters", ErrInvalidOptions, MinRampSize, MaxRampSize)
+	}
+	for _, c := range []byte(o.Ramp) {
+		if c < ' ' || c > '~' {
+			return fmt.Errorf("%w: ramp must only have printable ASCII characters", ErrInvalidOptions)
+		}
+	}
+	if _, err := ParsePipeline(o.Pipeline); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidOptions, err)
+	}
+	return nil
+}
+
+// Hash identifies the renderings made with o.
+func (o Options) Hash() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%+v", o)))
+	return fmt.Sprintf("%x", sum[:4])
+}
@@ -0,0 +1,52 @@
+package render
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(o *Options)
+		valid  bool
+	}{
+		{"defaults", func(o *Options) {}, true},
+		{"braille", func(o *Options) { o.Mode = ModeBraille }, true},
+		{"unknown mode", func(o *Options) { o.Mode = "sixel" }, false},
+		{"zero width", func(o *Options) { o.Width = 0 }, false},
+		{"too wide", func(o *Options) { o.Width = MaxWidth + 1 }, false},
+		{"too high", func(o *Options) { o.Height = MaxHeight + 1 }, false},
+		{"flat ratio", func(o *Options) { o.Ratio = MinRatio / 2 }, false},
+		{"NaN ratio", func(o *Options) { o.Ratio = math.NaN() }, false},
+		{"single character ramp", func(o *Options) { o.Ramp = "@" }, false},
+		{"unprintable ramp", func(o *Options) { o.Ramp = " \x1b@" }, false},
+		{"pipeline", func(o *Options) { o.Pipeline = "contrast:1.5,dither:ordered" }, true},
+		{"unknown stage", func(o *Options) { o.Pipeline = "blur:2" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := DefaultOptions()
+			tt.modify(&o)
+			err := o.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("got %s, want valid options", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("got %v, want ErrInvalidOptions", err)
+			}
+		})
+	}
+}
+
+func TestOptionsHash(t *testing.T) {
+	o := DefaultOptions()
+	if o.Hash() != DefaultOptions().Hash() {
+		t.Error("equal options hash differently")
+	}
+	o.Invert = true
+	if o.Hash() == DefaultOptions().Hash() {
+		t.Error("different options hash the same")
+	}
+}
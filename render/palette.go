@@ -0,0 +1,157 @@
+package render
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// Palette is the set of colors a terminal can display.
+type Palette string
+
+const (
+	PaletteTrueColor Palette = "truecolor"
+	Palette256       Palette = "256"
+	Palette16        Palette = "16"
+	PaletteMono      Palette = "mono"
+)
+
+var Palettes = []Palette{PaletteTrueColor, Palette256, Palette16, PaletteMono}
+
+func (p Palette) Valid() bool {
+	for _, palette := range Palettes {
+		if p == palette {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectPalette picks the palette of the terminal from the NO_COLOR,
+// COLORTERM and TERM environment variables read with getenv.
+func DetectPalette(getenv func(string) string) Palette {
+	term := getenv("TERM")
+	switch {
+	case getenv("NO_COLOR") != "", term == "dumb":
+		return PaletteMono
+	case getenv("COLORTERM") == "truecolor", getenv("COLORTERM") == "24bit":
+		return PaletteTrueColor
+	case strings.Contains(term, "256color"):
+		return Palette256
+	case term == "":
+		return PaletteMono
+	}
+	return Palette16
+}
+
+// ansi16Colors are the colors of the standard and bright ANSI colors, as
+// displayed by xterm.
+var ansi16Colors = [16]color.NRGBA{
+	{0, 0, 0, 255}, {205, 0, 0, 255}, {0, 205, 0, 255}, {205, 205, 0, 255},
+	{0, 0, 238, 255}, {205, 0, 205, 255}, {0, 205, 205, 255}, {229, 229, 229, 255},
+	{127, 127, 127, 255}, {255, 0, 0, 255}, {0, 255, 0, 255}, {255, 255, 0, 255},
+	{92, 92, 255, 255}, {255, 0, 255, 255}, {0, 255, 255, 255}, {255, 255, 255, 255},
+}
+
+// cubeLevels are the channel values of the 6x6x6 color cube of the 256
+// colors palette.
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+func distance(a color.NRGBA, r, g, b int) int {
+	dr, dg, db := int(a.R)-r, int(a.G)-g, int(a.B)-b
+	return dr*dr + dg*dg + db*db
+}
+
+func nearestCubeLevel(v uint8) int {
+	best := 0
+	for i, level := range cubeLevels {
+		if abs(int(v)-level) < abs(int(v)-cubeLevels[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// code256 is the nearest color of the 256 colors palette, either in the
+// color cube or in the grayscale ramp.
+func code256(c color.NRGBA) int {
+	r, g, b := nearestCubeLevel(c.R), nearestCubeLevel(c.G), nearestCubeLevel(c.B)
+	cube := 16 + 36*r + 6*g + b
+	cubeDistance := distance(c, cubeLevels[r], cubeLevels[g], cubeLevels[b])
+
+	// the grayscale ramp goes from 8 to 238 by 10
+	mean := (int(c.R) + int(c.G) + int(c.B)) / 3
+	gray := (mean - 3) / 10
+	if gray < 0 {
+		gray = 0
+	} else if gray > 23 {
+		gray = 23
+	}
+	level := 8 + 10*gray
+	if distance(c, level, level, level) < cubeDistance {
+		return 232 + gray
+	}
+	return cube
+}
+
+// code16 is the index of the nearest ANSI color.
+func code16(c color.NRGBA) int {
+	best := 0
+	for i, candidate := range ansi16Colors {
+		if distance(c, int(candidate.R), int(candidate.G), int(candidate.B)) <
+			distance(c, int(ansi16Colors[best].R), int(ansi16Colors[best].G), int(ansi16Colors[best].B)) {
+			best = i
+		}
+	}
+	return best
+}
+
+// sgr returns the parameters of the Select Graphic Rendition escape code
+// setting c as the foreground, or the background when bg is set.
+func (p Palette) sgr(c color.NRGBA, bg bool) string {
+	switch p {
+	case PaletteTrueColor:
+		if bg {
+			return fmt.Sprintf("48;2;%d;%d;%d", c.R, c.G, c.B)
+		}
+		return fmt.Sprintf("38;2;%d;%d;%d", c.R, c.G, c.B)
+	case Palette256:
+		if bg {
+			return fmt.Sprintf("48;5;%d", code256(c))
+		}
+		return fmt.Sprintf("38;5;%d", code256(c))
+	case Palette16:
+		code := code16(c)
+		base := 30
+		if code >= 8 {
+			base, code = 90, code-8
+		}
+		if bg {
+			base += 10
+		}
+		return fmt.Sprint(base + code)
+	}
+	return ""
+}
+
+// monoBlock draws a cell with a background without colors, lighting the
+// halves of the block brighter than the middle gray.
+func monoBlock(cell Cell) rune {
+	top, bottom := intensity(cell.Color) > 0.5, intensity(cell.Background) > 0.5
+	switch {
+	case top && bottom:
+		return fullBlock
+	case top:
+		return upperHalfBlock
+	case bottom:
+		return lowerHalfBlock
+	}
+	return ' '
+}
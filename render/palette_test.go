@@ -0,0 +1,24 @@
+package render
+
+import "testing"
+
+func TestDetectPalette(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want Palette
+	}{
+		{map[string]string{}, PaletteMono},
+		{map[string]string{"TERM": "xterm"}, Palette16},
+		{map[string]string{"TERM": "xterm-256color"}, Palette256},
+		{map[string]string{"TERM": "xterm-256color", "COLORTERM": "truecolor"}, PaletteTrueColor},
+		{map[string]string{"TERM": "xterm", "COLORTERM": "24bit"}, PaletteTrueColor},
+		{map[string]string{"TERM": "xterm-256color", "NO_COLOR": "1"}, PaletteMono},
+		{map[string]string{"TERM": "dumb", "COLORTERM": "truecolor"}, PaletteMono},
+	}
+	for _, tt := range tests {
+		got := DetectPalette(func(key string) string { return tt.env[key] })
+		if got != tt.want {
+			t.Errorf("DetectPalette(%v) = %s, want %s", tt.env, got, tt.want)
+		}
+	}
+}
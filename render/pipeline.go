@@ -0,0 +1,269 @@
+package render
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MaxPipelineStages bounds the stages of a pipeline.
+const MaxPipelineStages = 8
+
+// Stage transforms the pixels of an image before they are mapped to
+// characters. levels is the number of intensities the mode can draw: the
+// size of the ramp, or 2 for the modes drawing dots and blocks.
+type Stage interface {
+	Apply(pixels [][]color.NRGBA, levels int)
+}
+
+// ParsePipeline parses comma separated stages applied in order, each one
+// being a name followed by its parameter after a colon:
+//
+//	gamma:<0.1 to 10>        raises the tones to 1/gamma, above 1 brightens
+//	contrast:<0 to 10>       scales the tones around the middle gray
+//	brightness:<-1 to 1>     shifts the tones
+//	equalize                 spreads the tones over the whole range
+//	edges                    keeps the edges only, with the Sobel operator
+//	dither:floyd-steinberg   diffuses the quantization error
+//	dither:ordered           dithers with a 4x4 Bayer matrix
+func ParsePipeline(s string) ([]Stage, error) {
+	if s == "" {
+		return nil, nil
+	}
+	specs := strings.Split(s, ",")
+	if len(specs) > MaxPipelineStages {
+		return nil, fmt.Errorf("pipeline must have at most %d stages", MaxPipelineStages)
+	}
+	stages := make([]Stage, 0, len(specs))
+	for _, spec := range specs {
+		name, param, _ := strings.Cut(spec, ":")
+		var stage Stage
+		var err error
+		switch name {
+		case "gamma":
+			var v float64
+			v, err = parseStageParam(name, param, 0.1, 10)
+			stage = gammaStage(v)
+		case "contrast":
+			var v float64
+			v, err = parseStageParam(name, param, 0, 10)
+			stage = contrastStage(v)
+		case "brightness":
+			var v float64
+			v, err = parseStageParam(name, param, -1, 1)
+			stage = brightnessStage(v)
+		case "equalize":
+			stage = equalizeStage{}
+		case "edges":
+			stage = edgesStage{}
+		case "dither":
+			switch param {
+			case "floyd-steinberg":
+				stage = floydSteinbergStage{}
+			case "ordered":
+				stage = orderedStage{}
+			default:
+				err = fmt.Errorf("unknown dither %q, want floyd-steinberg or ordered", param)
+			}
+		default:
+			err = fmt.Errorf("unknown stage %q", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+func parseStageParam(name, param string, min, max float64) (float64, error) {
+	v, err := strconv.ParseFloat(param, 64)
+	if err != nil || v < min || v > max || math.IsNaN(v) {
+		return 0, fmt.Errorf("%s must be between %g and %g", name, min, max)
+	}
+	return v, nil
+}
+
+// mapTones applies f to every color channel, as a value between 0 and 1.
+func mapTones(pixels [][]color.NRGBA, f func(float64) float64) {
+	var table [256]uint8
+	for i := range table {
+		table[i] = clampChannel(f(float64(i) / 255))
+	}
+	for _, row := range pixels {
+		for x, c := range row {
+			row[x] = color.NRGBA{R: table[c.R], G: table[c.G], B: table[c.B], A: c.A}
+		}
+	}
+}
+
+func clampChannel(v float64) uint8 {
+	return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+}
+
+// withIntensity darkens c or lightens it toward white so that its
+// intensity becomes v, keeping its hue.
+func withIntensity(c color.NRGBA, v float64) color.NRGBA {
+	if c.A == 0 {
+		return c
+	}
+	// intensity is weighted by alpha
+	target := math.Min(1, v*255/float64(c.A))
+	current := float64(int(c.R)+int(c.G)+int(c.B)) / (3 * 255)
+	if target == current {
+		return c
+	}
+	channel := func(ch uint8) uint8 {
+		f := float64(ch) / 255
+		if target <= current {
+			return clampChannel(f * target / current)
+		}
+		return clampChannel(f + (1-f)*(target-current)/(1-current))
+	}
+	return color.NRGBA{R: channel(c.R), G: channel(c.G), B: channel(c.B), A: c.A}
+}
+
+// quantize rounds v to the nearest of levels intensities.
+func quantize(v float64, levels int) float64 {
+	steps := float64(levels - 1)
+	return math.Round(math.Max(0, math.Min(1, v))*steps) / steps
+}
+
+type gammaStage float64
+
+func (s gammaStage) Apply(pixels [][]color.NRGBA, _ int) {
+	mapTones(pixels, func(v float64) float64 {
+		return math.Pow(v, 1/float64(s))
+	})
+}
+
+type contrastStage float64
+
+func (s contrastStage) Apply(pixels [][]color.NRGBA, _ int) {
+	mapTones(pixels, func(v float64) float64 {
+		return (v-0.5)*float64(s) + 0.5
+	})
+}
+
+type brightnessStage float64
+
+func (s brightnessStage) Apply(pixels [][]color.NRGBA, _ int) {
+	mapTones(pixels, func(v float64) float64 {
+		return v + float64(s)
+	})
+}
+
+// equalizeStage maps the intensities through their cumulative histogram.
+type equalizeStage struct{}
+
+func (equalizeStage) Apply(pixels [][]color.NRGBA, _ int) {
+	var histogram [256]int
+	n := 0
+	for _, row := range pixels {
+		for _, c := range row {
+			histogram[clampChannel(intensity(c))]++
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	var cdf [256]float64
+	sum := 0
+	for i, count := range histogram {
+		sum += count
+		cdf[i] = float64(sum) / float64(n)
+	}
+	for _, row := range pixels {
+		for x, c := range row {
+			row[x] = withIntensity(c, cdf[clampChannel(intensity(c))])
+		}
+	}
+}
+
+// edgesStage replaces the intensities by their gradient magnitude.
+type edgesStage struct{}
+
+func (edgesStage) Apply(pixels [][]color.NRGBA, _ int) {
+	height := len(pixels)
+	if height == 0 {
+		return
+	}
+	width := len(pixels[0])
+	at := func(x, y int) float64 {
+		x = int(math.Max(0, math.Min(float64(width-1), float64(x))))
+		y = int(math.Max(0, math.Min(float64(height-1), float64(y))))
+		return intensity(pixels[y][x])
+	}
+	magnitudes := make([][]float64, height)
+	for y := range pixels {
+		magnitudes[y] = make([]float64, width)
+		for x := range pixels[y] {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			// the magnitude of a step from black to white is 4
+			magnitudes[y][x] = math.Min(1, math.Hypot(gx, gy)/4)
+		}
+	}
+	for y, row := range pixels {
+		for x, c := range row {
+			row[x] = withIntensity(c, magnitudes[y][x])
+		}
+	}
+}
+
+// floydSteinbergStage quantizes the intensities to the drawable levels,
+// diffusing the error to the neighbor pixels.
+type floydSteinbergStage struct{}
+
+func (floydSteinbergStage) Apply(pixels [][]color.NRGBA, levels int) {
+	height := len(pixels)
+	if height == 0 {
+		return
+	}
+	width := len(pixels[0])
+	errs := make([][]float64, height)
+	for y := range errs {
+		errs[y] = make([]float64, width)
+	}
+	diffuse := func(x, y int, e float64) {
+		if x >= 0 && x < width && y < height {
+			errs[y][x] += e
+		}
+	}
+	for y, row := range pixels {
+		for x, c := range row {
+			v := intensity(c) + errs[y][x]
+			q := quantize(v, levels)
+			row[x] = withIntensity(c, q)
+			e := v - q
+			diffuse(x+1, y, e*7/16)
+			diffuse(x-1, y+1, e*3/16)
+			diffuse(x, y+1, e*5/16)
+			diffuse(x+1, y+1, e*1/16)
+		}
+	}
+}
+
+var bayer4 = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// orderedStage quantizes the intensities to the drawable levels, offset by
+// a Bayer threshold matrix.
+type orderedStage struct{}
+
+func (orderedStage) Apply(pixels [][]color.NRGBA, levels int) {
+	step := 1 / float64(levels-1)
+	for y, row := range pixels {
+		for x, c := range row {
+			offset := ((bayer4[y%4][x%4]+0.5)/16 - 0.5) * step
+			row[x] = withIntensity(c, quantize(intensity(c)+offset, levels))
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePipeline(t *testing.T) {
+	tests := []struct {
+		pipeline string
+		stages   int
+		valid    bool
+	}{
+		{"", 0, true},
+		{"gamma:2.2", 1, true},
+		{"equalize,edges,dither:floyd-steinberg", 3, true},
+		{"brightness:-0.5,contrast:10", 2, true},
+		{"gamma:0", 0, false},
+		{"gamma:NaN", 0, false},
+		{"contrast", 0, false},
+		{"dither:random", 0, false},
+		{"sharpen:1", 0, false},
+		{strings.Repeat("equalize,", MaxPipelineStages) + "edges", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pipeline, func(t *testing.T) {
+			stages, err := ParsePipeline(tt.pipeline)
+			if tt.valid != (err == nil) {
+				t.Fatalf("got error %v, want valid %t", err, tt.valid)
+			}
+			if len(stages) != tt.stages {
+				t.Errorf("got %d stages, want %d", len(stages), tt.stages)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package render
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+var goldenFixtures = []string{"gradient.png", "blink.gif"}
+
+// TestGolden renders the fixtures in every mode and encodes them in every
+// format, run with -update to record the outputs of an intended change.
+func TestGolden(t *testing.T) {
+	for _, fixture := range goldenFixtures {
+		for _, mode := range Modes {
+			opts := DefaultOptions()
+			opts.Mode = mode
+			opts.Width = 16
+			opts.Height = 8
+			canvas := decodeFixture(t, fixture, opts)
+			for _, format := range Formats {
+				name := fixture + "." + string(mode) + "." + format.Extension() + ".golden"
+				t.Run(name, func(t *testing.T) {
+					got, err := canvas.Encode(format)
+					if err != nil {
+						t.Fatalf("cannot encode %s: %s", format, err)
+					}
+					checkGolden(t, name, got)
+				})
+			}
+		}
+	}
+}
+
+func TestGoldenPalettes(t *testing.T) {
+	canvas := decodeFixture(t, "gradient.png", DefaultOptions())
+	for _, palette := range Palettes {
+		name := "gradient.png.ansi." + string(palette) + ".golden"
+		t.Run(name, func(t *testing.T) {
+			checkGolden(t, name, []byte(canvas.ANSIPalette(palette)))
+		})
+	}
+}
+
+func decodeFixture(t *testing.T, fixture string, opts Options) *Canvas {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	canvas, err := Decode(f, opts)
+	if err != nil {
+		t.Fatalf("cannot decode %s: %s", fixture, err)
+	}
+	return canvas
+}
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s, run the tests with -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s, run the tests with -update if the change is intended\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
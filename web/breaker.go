@@ -0,0 +1,369 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (cs CircuitState) String() string {
+	switch cs {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerPostCacheAdapter stops calling a failing cache after
+// threshold consecutive errors and serves a fallback cache instead. Once
+// cooldown elapsed, a single call probes the primary cache again. The other
+// adapters of the cache service go through the same circuit with Call.
+type CircuitBreakerPostCacheAdapter struct {
+	primary   PostCacheAdapter
+	fallback  PostCacheAdapter
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+	// deleted holds the posts evicted while the primary cache was
+	// unreachable
+	deleted map[uuid.UUID]struct{}
+}
+
+func NewCircuitBreakerPostCacheAdapter(primary, fallback PostCacheAdapter, threshold int, cooldown time.Duration) *CircuitBreakerPostCacheAdapter {
+	return &CircuitBreakerPostCacheAdapter{
+		primary:   primary,
+		fallback:  fallback,
+		threshold: threshold,
+		cooldown:  cooldown,
+		deleted:   make(map[uuid.UUID]struct{}),
+	}
+}
+
+func (cb *CircuitBreakerPostCacheAdapter) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// allow reports whether the call may go to the primary cache, and whether
+// it is the recovery probe.
+func (cb *CircuitBreakerPostCacheAdapter) allow() (bool, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false, false
+		}
+		cb.state = CircuitHalfOpen
+		cb.probing = true
+		return true, true
+	case CircuitHalfOpen:
+		if cb.probing {
+			return false, false
+		}
+		cb.probing = true
+		return true, true
+	}
+	return true, false
+}
+
+// begin reports whether the call may go to the primary cache. The
+// recovery probe first replays the evictions missed while open.
+func (cb *CircuitBreakerPostCacheAdapter) begin(ctx context.Context) (bool, bool) {
+	ok, probe := cb.allow()
+	if !probe {
+		return ok, false
+	}
+	if err := cb.replayDeleted(ctx); err != nil {
+		cb.record(err, true)
+		return false, false
+	}
+	return true, true
+}
+
+func (cb *CircuitBreakerPostCacheAdapter) replayDeleted(ctx context.Context) error {
+	cb.mu.Lock()
+	ids := make([]uuid.UUID, 0, len(cb.deleted))
+	for id := range cb.deleted {
+		ids = append(ids, id)
+	}
+	cb.mu.Unlock()
+
+	for _, id := range ids {
+		if err := cb.primary.DeletePost(ctx, id); err != nil {
+			return err
+		}
+		cb.mu.Lock()
+		delete(cb.deleted, id)
+		cb.mu.Unlock()
+	}
+	return nil
+}
+
+func (cb *CircuitBreakerPostCacheAdapter) record(err error, probe bool) {
+	failed := err != nil && err != ErrPostCacheNotFound && !errors.Is(err, context.Canceled)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if probe {
+		cb.probing = false
+	}
+	if !failed {
+		cb.failures = 0
+		if cb.state == CircuitHalfOpen {
+			cb.state = CircuitClosed
+			log.Println("cache circuit closed, cache service recovered")
+		}
+		return
+	}
+	cb.failures++
+	if cb.state == CircuitHalfOpen || (cb.state == CircuitClosed && cb.failures >= cb.threshold) {
+		if cb.state == CircuitClosed {
+			log.Printf("[WARNING] cache circuit open after %d failures, serving in-memory cache: %s\n", cb.failures, err)
+		}
+		cb.state = CircuitOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+func (cb *CircuitBreakerPostCacheAdapter) Ping(ctx context.Context) error {
+	ok, probe := cb.begin(ctx)
+	if !ok {
+		return cb.fallback.Ping(ctx)
+	}
+	err := cb.primary.Ping(ctx)
+	cb.record(err, probe)
+	return err
+}
+
+func (cb *CircuitBreakerPostCacheAdapter) CachePost(ctx context.Context, key PostCacheKey, entry []byte, ttl time.Duration) (bool, error) {
+	ok, probe := cb.begin(ctx)
+	if !ok {
+		return cb.fallback.CachePost(ctx, key, entry, ttl)
+	}
+	replaced, err := cb.primary.CachePost(ctx, key, entry, ttl)
+	cb.record(err, probe)
+	if err != nil {
+		return cb.fallback.CachePost(ctx, key, entry, ttl)
+	}
+	return replaced, nil
+}
+
+func (cb *CircuitBreakerPostCacheAdapter) GetPost(ctx context.Context, key PostCacheKey) ([]byte, error) {
+	ok, probe := cb.begin(ctx)
+	if !ok {
+		return cb.fallback.GetPost(ctx, key)
+	}
+	entry, err := cb.primary.GetPost(ctx, key)
+	cb.record(err, probe)
+	if err != nil && err != ErrPostCacheNotFound {
+		return cb.fallback.GetPost(ctx, key)
+	}
+	return entry, err
+}
+
+func (cb *CircuitBreakerPostCacheAdapter) DeletePost(ctx context.Context, id uuid.UUID) error {
+	cb.fallback.DeletePost(ctx, id)
+	ok, probe := cb.begin(ctx)
+	if ok {
+		err := cb.primary.DeletePost(ctx, id)
+		cb.record(err, probe)
+		if err == nil {
+			return nil
+		}
+	}
+	cb.mu.Lock()
+	cb.deleted[id] = struct{}{}
+	cb.mu.Unlock()
+	return nil
+}
+
+var ErrCircuitOpen = errors.New("cache circuit open")
+
+// Call runs a call to the cache service through the circuit, or returns
+// ErrCircuitOpen without calling it while the circuit is open.
+func (cb *CircuitBreakerPostCacheAdapter) Call(ctx context.Context, fn func() error) error {
+	ok, probe := cb.begin(ctx)
+	if !ok {
+		return ErrCircuitOpen
+	}
+	err := fn()
+	cb.record(err, probe)
+	return err
+}
+
+// CircuitBreakerLockAdapter takes locks through the circuit of the cache
+// service, renders go on without lock while it is open.
+type CircuitBreakerLockAdapter struct {
+	lock    PostLockAdapter
+	breaker *CircuitBreakerPostCacheAdapter
+}
+
+func NewCircuitBreakerLockAdapter(lock PostLockAdapter, breaker *CircuitBreakerPostCacheAdapter) *CircuitBreakerLockAdapter {
+	return &CircuitBreakerLockAdapter{
+		lock:    lock,
+		breaker: breaker,
+	}
+}
+
+func (a *CircuitBreakerLockAdapter) AcquireLock(ctx context.Context, key string, ttl time.Duration) (token string, acquired bool, err error) {
+	err = a.breaker.Call(ctx, func() error {
+		token, acquired, err = a.lock.AcquireLock(ctx, key, ttl)
+		return err
+	})
+	return token, acquired, err
+}
+
+func (a *CircuitBreakerLockAdapter) ReleaseLock(ctx context.Context, key string, token string) error {
+	return a.breaker.Call(ctx, func() error {
+		return a.lock.ReleaseLock(ctx, key, token)
+	})
+}
+
+// CircuitBreakerVoteBufferAdapter buffers votes through the circuit of the
+// cache service, votes are written to the database while it is open.
+type CircuitBreakerVoteBufferAdapter struct {
+	buffer  PostVoteBufferAdapter
+	breaker *CircuitBreakerPostCacheAdapter
+}
+
+func NewCircuitBreakerVoteBufferAdapter(buffer PostVoteBufferAdapter, breaker *CircuitBreakerPostCacheAdapter) *CircuitBreakerVoteBufferAdapter {
+	return &CircuitBreakerVoteBufferAdapter{
+		buffer:  buffer,
+		breaker: breaker,
+	}
+}
+
+func (a *CircuitBreakerVoteBufferAdapter) BufferVote(ctx context.Context, vote Vote) error {
+	return a.breaker.Call(ctx, func() error {
+		return a.buffer.BufferVote(ctx, vote)
+	})
+}
+
+func (a *CircuitBreakerVoteBufferAdapter) PendingScores(ctx context.Context) (scores map[uuid.UUID]int, err error) {
+	err = a.breaker.Call(ctx, func() error {
+		scores, err = a.buffer.PendingScores(ctx)
+		return err
+	})
+	return scores, err
+}
+
+func (a *CircuitBreakerVoteBufferAdapter) ClaimVoteBatch(ctx context.Context, maxAttempts int) (batch VoteBatch, err error) {
+	err = a.breaker.Call(ctx, func() error {
+		batch, err = a.buffer.ClaimVoteBatch(ctx, maxAttempts)
+		return err
+	})
+	return batch, err
+}
+
+func (a *CircuitBreakerVoteBufferAdapter) AckVoteBatch(ctx context.Context, batchID string) error {
+	return a.breaker.Call(ctx, func() error {
+		return a.buffer.AckVoteBatch(ctx, batchID)
+	})
+}
+
+func (a *CircuitBreakerVoteBufferAdapter) DeadVotes(ctx context.Context) (n int64, err error) {
+	err = a.breaker.Call(ctx, func() error {
+		n, err = a.buffer.DeadVotes(ctx)
+		return err
+	})
+	return n, err
+}
+
+func (a *CircuitBreakerVoteBufferAdapter) ReplayDeadVotes(ctx context.Context) (n int64, err error) {
+	err = a.breaker.Call(ctx, func() error {
+		n, err = a.buffer.ReplayDeadVotes(ctx)
+		return err
+	})
+	return n, err
+}
+
+// CircuitBreakerRenderQueueAdapter queues renders through the circuit of
+// the cache service, posts are rendered inline while it is open.
+type CircuitBreakerRenderQueueAdapter struct {
+	queue   RenderQueueAdapter
+	breaker *CircuitBreakerPostCacheAdapter
+}
+
+func NewCircuitBreakerRenderQueueAdapter(queue RenderQueueAdapter, breaker *CircuitBreakerPostCacheAdapter) *CircuitBreakerRenderQueueAdapter {
+	return &CircuitBreakerRenderQueueAdapter{
+		queue:   queue,
+		breaker: breaker,
+	}
+}
+
+func (a *CircuitBreakerRenderQueueAdapter) EnqueueRender(ctx context.Context, job RenderJob, pendingTTL time.Duration, maxLength int) (queued bool, err error) {
+	err = a.breaker.Call(ctx, func() error {
+		queued, err = a.queue.EnqueueRender(ctx, job, pendingTTL, maxLength)
+		if err == ErrRenderQueueFull {
+			// a full queue is not a failure of the cache service
+			return nil
+		}
+		return err
+	})
+	return queued, err
+}
+
+// DequeueRender waits for a job through the circuit for a bounded time
+// only, so that a worker waiting for jobs does not hold the recovery probe
+// and keep every other call on the fallback.
+func (a *CircuitBreakerRenderQueueAdapter) DequeueRender(ctx context.Context) (job RenderJob, err error) {
+	err = a.breaker.Call(ctx, func() error {
+		job, err = a.queue.DequeueRender(ctx)
+		if err == ErrRenderQueueEmpty {
+			return nil
+		}
+		return err
+	})
+	return job, err
+}
+
+func (a *CircuitBreakerRenderQueueAdapter) DoneRender(ctx context.Context, job RenderJob) error {
+	return a.breaker.Call(ctx, func() error {
+		return a.queue.DoneRender(ctx, job)
+	})
+}
+
+// CircuitBreakerInvalidationAdapter publishes invalidations through the
+// circuit of the cache service, none are published while it is open.
+type CircuitBreakerInvalidationAdapter struct {
+	PostInvalidationAdapter
+	breaker *CircuitBreakerPostCacheAdapter
+}
+
+func NewCircuitBreakerInvalidationAdapter(a PostInvalidationAdapter, breaker *CircuitBreakerPostCacheAdapter) *CircuitBreakerInvalidationAdapter {
+	return &CircuitBreakerInvalidationAdapter{
+		PostInvalidationAdapter: a,
+		breaker:                 breaker,
+	}
+}
+
+func (a *CircuitBreakerInvalidationAdapter) PublishInvalidation(ctx context.Context, inv PostInvalidation) error {
+	return a.breaker.Call(ctx, func() error {
+		return a.PostInvalidationAdapter.PublishInvalidation(ctx, inv)
+	})
+}
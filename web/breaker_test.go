@@ -0,0 +1,166 @@
+package web_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/web"
+	"github.com/skale-5/skalogram/web/pkg/memory"
+)
+
+var errUnreachable = errors.New("unreachable")
+
+// flakyCache is a cache failing every call while down.
+type flakyCache struct {
+	*memory.Client
+	down  bool
+	calls int
+}
+
+func (c *flakyCache) GetPost(ctx context.Context, key web.PostCacheKey) ([]byte, error) {
+	c.calls++
+	if c.down {
+		return nil, errUnreachable
+	}
+	return c.Client.GetPost(ctx, key)
+}
+
+func (c *flakyCache) DeletePost(ctx context.Context, id uuid.UUID) error {
+	if c.down {
+		return errUnreachable
+	}
+	return c.Client.DeletePost(ctx, id)
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	ctx := context.Background()
+	primary := &flakyCache{Client: memory.NewClient(10, 0), down: true}
+	fallback := memory.NewClient(10, 0)
+	cb := web.NewCircuitBreakerPostCacheAdapter(primary, fallback, 2, 50*time.Millisecond)
+	key := web.PostCacheKey{ID: uuid.New(), Kind: web.PostCacheEntryHTML}
+	fallback.CachePost(ctx, key, []byte("fallback"), 0)
+
+	for i := 0; i < 2; i++ {
+		entry, err := cb.GetPost(ctx, key)
+		if err != nil || string(entry) != "fallback" {
+			t.Fatalf("got %q, %v, want the fallback entry", entry, err)
+		}
+	}
+	if cb.State() != web.CircuitOpen {
+		t.Fatalf("got %s circuit after 2 failures, want open", cb.State())
+	}
+	calls := primary.calls
+	cb.GetPost(ctx, key)
+	if primary.calls != calls {
+		t.Error("open circuit called the primary cache")
+	}
+	if err := cb.Call(ctx, func() error { return nil }); !errors.Is(err, web.ErrCircuitOpen) {
+		t.Errorf("got %v, want ErrCircuitOpen", err)
+	}
+
+	primary.down = false
+	time.Sleep(60 * time.Millisecond)
+	if _, err := cb.GetPost(ctx, key); err != web.ErrPostCacheNotFound {
+		t.Fatalf("got %v from the probe, want a miss from the primary cache", err)
+	}
+	if cb.State() != web.CircuitClosed {
+		t.Errorf("got %s circuit after a successful probe, want closed", cb.State())
+	}
+}
+
+func TestCircuitBreakerReplaysDeletes(t *testing.T) {
+	ctx := context.Background()
+	primary := &flakyCache{Client: memory.NewClient(10, 0)}
+	cb := web.NewCircuitBreakerPostCacheAdapter(primary, memory.NewClient(10, 0), 1, 10*time.Millisecond)
+	key := web.PostCacheKey{ID: uuid.New(), Kind: web.PostCacheEntryHTML}
+	primary.CachePost(ctx, key, []byte("stale"), 0)
+
+	primary.down = true
+	cb.DeletePost(ctx, key.ID)
+	primary.down = false
+	time.Sleep(20 * time.Millisecond)
+	if _, err := cb.GetPost(ctx, key); err != web.ErrPostCacheNotFound {
+		t.Errorf("got %v, want the post deleted while open to be deleted on recovery", err)
+	}
+}
+
+// pollQueue is a render queue waiting for jobs for a bounded time, like the
+// blocking pop of the Redis queue.
+type pollQueue struct {
+	jobs chan web.RenderJob
+}
+
+func (q *pollQueue) EnqueueRender(ctx context.Context, job web.RenderJob, pendingTTL time.Duration, maxLength int) (bool, error) {
+	q.jobs <- job
+	return true, nil
+}
+
+func (q *pollQueue) DequeueRender(ctx context.Context) (web.RenderJob, error) {
+	select {
+	case job := <-q.jobs:
+		return job, nil
+	case <-time.After(20 * time.Millisecond):
+		return web.RenderJob{}, web.ErrRenderQueueEmpty
+	}
+}
+
+func (q *pollQueue) DoneRender(ctx context.Context, job web.RenderJob) error {
+	return nil
+}
+
+func TestCircuitBreakerRecoversWhileDequeueing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	primary := &flakyCache{Client: memory.NewClient(10, 0), down: true}
+	cb := web.NewCircuitBreakerPostCacheAdapter(primary, memory.NewClient(10, 0), 1, 10*time.Millisecond)
+	queue := web.NewCircuitBreakerRenderQueueAdapter(&pollQueue{jobs: make(chan web.RenderJob, 1)}, cb)
+	cb.GetPost(ctx, web.PostCacheKey{ID: uuid.New(), Kind: web.PostCacheEntryHTML})
+	primary.down = false
+	time.Sleep(20 * time.Millisecond)
+
+	dequeued := make(chan web.RenderJob)
+	go func() {
+		for ctx.Err() == nil {
+			job, err := queue.DequeueRender(ctx)
+			if err == nil {
+				dequeued <- job
+				return
+			}
+		}
+	}()
+
+	job := web.RenderJob{PostID: uuid.New()}
+	deadline := time.Now().Add(time.Second)
+	for {
+		queued, err := queue.EnqueueRender(ctx, job, time.Minute, 10)
+		if err == nil && queued {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v with a %s circuit, want the job queued once the cache recovered", err, cb.State())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case got := <-dequeued:
+		if got.PostID != job.PostID {
+			t.Errorf("got post %s, want %s", got.PostID, job.PostID)
+		}
+	case <-time.After(time.Second):
+		t.Error("worker did not dequeue the job")
+	}
+	if cb.State() != web.CircuitClosed {
+		t.Errorf("got %s circuit, want closed", cb.State())
+	}
+}
+
+func TestCircuitBreakerIgnoresCanceledCalls(t *testing.T) {
+	cb := web.NewCircuitBreakerPostCacheAdapter(memory.NewClient(10, 0), memory.NewClient(10, 0), 1, time.Minute)
+	cb.Call(context.Background(), func() error { return context.Canceled })
+	if cb.State() != web.CircuitClosed {
+		t.Errorf("got %s circuit after a canceled call, want closed", cb.State())
+	}
+}
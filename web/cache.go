@@ -0,0 +1,68 @@
+package web
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TwoLevelPostCacheAdapter serves posts from a fast local cache (L1) in
+// front of a shared one (L2). L1 entries live at most l1TTL, which bounds
+// how long an instance may serve an entry changed by another instance.
+type TwoLevelPostCacheAdapter struct {
+	l1    PostCacheAdapter
+	l2    PostCacheAdapter
+	l1TTL time.Duration
+}
+
+func NewTwoLevelPostCacheAdapter(l1, l2 PostCacheAdapter, l1TTL time.Duration) *TwoLevelPostCacheAdapter {
+	return &TwoLevelPostCacheAdapter{
+		l1:    l1,
+		l2:    l2,
+		l1TTL: l1TTL,
+	}
+}
+
+func (c *TwoLevelPostCacheAdapter) Ping(ctx context.Context) error {
+	return c.l2.Ping(ctx)
+}
+
+// CachePost reports whether the shared entry was replaced.
+func (c *TwoLevelPostCacheAdapter) CachePost(ctx context.Context, key PostCacheKey, entry []byte, ttl time.Duration) (bool, error) {
+	replaced, err := c.l2.CachePost(ctx, key, entry, ttl)
+	if err != nil {
+		// never keep locally what the other instances cannot see
+		c.l1.DeletePost(ctx, key.ID)
+		return false, err
+	}
+	if _, err := c.l1.CachePost(ctx, key, entry, c.localTTL(ttl)); err != nil {
+		return false, err
+	}
+	return replaced, nil
+}
+
+func (c *TwoLevelPostCacheAdapter) GetPost(ctx context.Context, key PostCacheKey) ([]byte, error) {
+	entry, err := c.l1.GetPost(ctx, key)
+	if err == nil {
+		return entry, nil
+	}
+	entry, err = c.l2.GetPost(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	c.l1.CachePost(ctx, key, entry, c.l1TTL)
+	return entry, nil
+}
+
+func (c *TwoLevelPostCacheAdapter) DeletePost(ctx context.Context, id uuid.UUID) error {
+	c.l1.DeletePost(ctx, id)
+	return c.l2.DeletePost(ctx, id)
+}
+
+func (c *TwoLevelPostCacheAdapter) localTTL(ttl time.Duration) time.Duration {
+	if ttl > 0 && ttl < c.l1TTL {
+		return ttl
+	}
+	return c.l1TTL
+}
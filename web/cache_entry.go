@@ -0,0 +1,113 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/render"
+)
+
+type PostCacheEntryKind string
+
+const (
+	// PostCacheEntryHTML is the rendering embedded in the pages.
+	PostCacheEntryHTML PostCacheEntryKind = "html"
+	// PostCacheEntryCanvas is the rendering every output format derives from.
+	PostCacheEntryCanvas PostCacheEntryKind = "canvas"
+)
+
+var PostCacheEntryKinds = []PostCacheEntryKind{
+	PostCacheEntryHTML,
+	PostCacheEntryCanvas,
+}
+
+type PostCacheKey struct {
+	ID   uuid.UUID
+	Kind PostCacheEntryKind
+	// Variant is the hash of the render options, empty for the defaults.
+	Variant string
+}
+
+func (k PostCacheKey) String() string {
+	if k.Variant == "" {
+		return k.ID.String() + ":" + string(k.Kind)
+	}
+	return k.ID.String() + ":" + string(k.Kind) + ":" + k.Variant
+}
+
+// ErrPostCacheDecode is returned when a cached entry exists but cannot be
+// decoded, unlike ErrPostCacheNotFound.
+var ErrPostCacheDecode = errors.New("cannot decode cached post")
+
+// Entries are stored as "<kind> <expiry>\n<payload>", so that reading an
+// entry of another kind fails to decode instead of being mistaken for a
+// valid one. The expiry, in Unix milliseconds, tells how long the entry
+// lives without asking the cache.
+func encodePostCacheEntry(kind PostCacheEntryKind, expiresAt time.Time, payload []byte) []byte {
+	entry := make([]byte, 0, len(kind)+16+len(payload))
+	entry = append(entry, kind...)
+	entry = append(entry, ' ')
+	entry = strconv.AppendInt(entry, expiresAt.UnixMilli(), 10)
+	entry = append(entry, '\n')
+	return append(entry, payload...)
+}
+
+func decodePostCacheEntry(kind PostCacheEntryKind, entry []byte) ([]byte, time.Time, error) {
+	header, payload, ok := bytes.Cut(entry, []byte("\n"))
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("%w: missing entry header", ErrPostCacheDecode)
+	}
+	header, expiry, hasExpiry := bytes.Cut(header, []byte(" "))
+	if PostCacheEntryKind(header) != kind {
+		return nil, time.Time{}, fmt.Errorf("%w: got a %q entry, want %q", ErrPostCacheDecode, header, kind)
+	}
+	if !hasExpiry {
+		// entries cached before their expiry was stored
+		return payload, time.Time{}, nil
+	}
+	expiresAtMilli, err := strconv.ParseInt(string(expiry), 10, 64)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: malformed expiry %q", ErrPostCacheDecode, expiry)
+	}
+	return payload, time.UnixMilli(expiresAtMilli), nil
+}
+
+func encodePostCacheText(kind PostCacheEntryKind, expiresAt time.Time, text string) []byte {
+	return encodePostCacheEntry(kind, expiresAt, []byte(text))
+}
+
+func decodePostCacheText(kind PostCacheEntryKind, entry []byte) (string, time.Time, error) {
+	payload, expiresAt, err := decodePostCacheEntry(kind, entry)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !utf8.Valid(payload) {
+		return "", time.Time{}, fmt.Errorf("%w: invalid UTF-8 %s", ErrPostCacheDecode, kind)
+	}
+	return string(payload), expiresAt, nil
+}
+
+func encodePostCacheCanvas(expiresAt time.Time, canvas *render.Canvas) ([]byte, error) {
+	payload, err := canvas.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return encodePostCacheEntry(PostCacheEntryCanvas, expiresAt, payload), nil
+}
+
+func decodePostCacheCanvas(entry []byte) (*render.Canvas, time.Time, error) {
+	payload, expiresAt, err := decodePostCacheEntry(PostCacheEntryCanvas, entry)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	canvas := &render.Canvas{}
+	if err := canvas.UnmarshalBinary(payload); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %s", ErrPostCacheDecode, err)
+	}
+	return canvas, expiresAt, nil
+}
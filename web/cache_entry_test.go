@@ -0,0 +1,73 @@
+package web
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/skale-5/skalogram/render"
+)
+
+func TestPostCacheTextRoundTrip(t *testing.T) {
+	expiresAt := time.UnixMilli(time.Now().Add(time.Minute).UnixMilli())
+	entry := encodePostCacheText(PostCacheEntryHTML, expiresAt, "<span>@</span>")
+	html, gotExpiresAt, err := decodePostCacheText(PostCacheEntryHTML, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html != "<span>@</span>" || !gotExpiresAt.Equal(expiresAt) {
+		t.Errorf("got %q expiring at %s, want %q expiring at %s", html, gotExpiresAt, "<span>@</span>", expiresAt)
+	}
+}
+
+func TestPostCacheEntryNeverExpiring(t *testing.T) {
+	entry := encodePostCacheText(PostCacheEntryHTML, cacheExpiry(0), "@")
+	_, expiresAt, err := decodePostCacheText(PostCacheEntryHTML, entry)
+	if err != nil || !expiresAt.IsZero() {
+		t.Errorf("got expiry %s, %v, want zero", expiresAt, err)
+	}
+}
+
+func TestPostCacheCanvasRoundTrip(t *testing.T) {
+	canvas := &render.Canvas{Rows: [][]render.Cell{{{Char: '@'}, {Char: ' '}}}}
+	expiresAt := time.UnixMilli(time.Now().Add(time.Minute).UnixMilli())
+	entry, err := encodePostCacheCanvas(expiresAt, canvas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, gotExpiresAt, err := decodePostCacheCanvas(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, canvas) || !gotExpiresAt.Equal(expiresAt) {
+		t.Errorf("got %+v expiring at %s, want %+v expiring at %s", got, gotExpiresAt, canvas, expiresAt)
+	}
+}
+
+func TestPostCacheEntryLegacyHeader(t *testing.T) {
+	html, expiresAt, err := decodePostCacheText(PostCacheEntryHTML, []byte("html\n@"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html != "@" || !expiresAt.IsZero() {
+		t.Errorf("got %q expiring at %s, want %q without expiry", html, expiresAt, "@")
+	}
+}
+
+func TestPostCacheEntryDecodeErrors(t *testing.T) {
+	entries := map[string][]byte{
+		"no header":        []byte("@"),
+		"other kind":       encodePostCacheText(PostCacheEntryCanvas, time.Now(), "@"),
+		"malformed expiry": []byte("html soon\n@"),
+		"invalid UTF-8":    encodePostCacheText(PostCacheEntryHTML, time.Now(), "\xff"),
+	}
+	for name, entry := range entries {
+		if _, _, err := decodePostCacheText(PostCacheEntryHTML, entry); !errors.Is(err, ErrPostCacheDecode) {
+			t.Errorf("%s: got %v, want ErrPostCacheDecode", name, err)
+		}
+	}
+	if _, _, err := decodePostCacheCanvas(encodePostCacheText(PostCacheEntryCanvas, time.Now(), "@")); !errors.Is(err, ErrPostCacheDecode) {
+		t.Errorf("malformed canvas: got %v, want ErrPostCacheDecode", err)
+	}
+}
@@ -6,12 +6,18 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/skale-5/skalogram/render"
 	"github.com/skale-5/skalogram/web"
 	"github.com/skale-5/skalogram/web/config"
 	"github.com/skale-5/skalogram/web/delivery/http"
 	"github.com/skale-5/skalogram/web/pkg/gcs"
+	"github.com/skale-5/skalogram/web/pkg/memory"
 	"github.com/skale-5/skalogram/web/pkg/s3"
 
 	"github.com/skale-5/skalogram/web/pkg/postgresql/post"
@@ -23,6 +29,7 @@ import (
 func main() {
 
 	isPrintDefaults := flag.Bool("print-defaults", false, "Print default configurations")
+	isRecomputeScores := flag.Bool("recompute-scores", false, "Recompute post scores from the vote event log and exit")
 	flag.Parse()
 
 	if *isPrintDefaults {
@@ -33,21 +40,38 @@ func main() {
 	ctx := context.Background()
 
 	// DATABASE SERVICE
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+	db, err := sql.Open("postgres", postgresInfo(
 		config.Env().Get("PG_HOST"),
 		config.Env().Get("PG_PORT"),
-		config.Env().Get("PG_USER"),
-		config.Env().Get("PG_PASSWORD"),
-		config.Env().Get("PG_DBNAME"),
-	)
-
-	db, err := sql.Open("postgres", psqlInfo)
+	))
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	store := post.NewStore(db)
+	if replicaHosts := config.Env().Get("PG_REPLICA_HOSTS"); replicaHosts != "" {
+		replicaSet := post.NewReplicaSet(db)
+		for _, replicaHost := range strings.Split(replicaHosts, ",") {
+			host, port := strings.TrimSpace(replicaHost), config.Env().Get("PG_PORT")
+			if h, p, err := net.SplitHostPort(host); err == nil {
+				host, port = h, p
+			}
+			replicaDB, err := sql.Open("postgres", postgresInfo(host, port))
+			if err != nil {
+				log.Fatal(err)
+			}
+			replicaSet.AddReplica(net.JoinHostPort(host, port), replicaDB)
+		}
+		replicaHealthInterval, err := time.ParseDuration(config.Env().Get("PG_REPLICA_HEALTH_INTERVAL"))
+		if err != nil {
+			log.Fatalf("invalid PG_REPLICA_HEALTH_INTERVAL duration format: %s", err)
+		}
+		go replicaSet.RunHealthChecks(ctx, replicaHealthInterval)
+		store = store.WithReader(replicaSet)
+	}
+
 	postDatabaseService := web.NewPostDatabaseService(
-		post.New(db),
+		store,
 	)
 
 	err = postDatabaseService.CreateTable(ctx)
@@ -55,18 +79,169 @@ func main() {
 		//log.Fatalf("failed to init table on startup: %s\n", err.Error())
 	}
 
+	if *isRecomputeScores {
+		if err := postDatabaseService.RecomputeScores(ctx); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("post scores recomputed from vote event log")
+		os.Exit(0)
+	}
+
+	// REPORT SERVICE
+	reportHideThreshold, err := strconv.Atoi(config.Env().Get("REPORT_HIDE_THRESHOLD"))
+	if err != nil {
+		log.Fatalf("invalid REPORT_HIDE_THRESHOLD: %s", err)
+	}
+	reportRateLimit, err := strconv.Atoi(config.Env().Get("REPORT_RATE_LIMIT"))
+	if err != nil {
+		log.Fatalf("invalid REPORT_RATE_LIMIT: %s", err)
+	}
+	reportService := web.NewReportService(web.NewReportServiceArgs{
+		Adapter:             store,
+		PostDatabaseService: postDatabaseService,
+		HideThreshold:       reportHideThreshold,
+		RateLimit:           reportRateLimit,
+	})
+
+	// USER SERVICE
+	userService := web.NewUserService(store, postDatabaseService)
+	if adminPassword := config.Env().Get("ADMIN_PASSWORD"); adminPassword != "" {
+		err := userService.SaveUser(ctx, config.Env().Get("ADMIN_USER"), adminPassword, web.RoleAdmin)
+		if err != nil {
+			log.Printf("[WARNING] failed to bootstrap admin user: %s\n", err)
+		}
+	}
+
+	// RENDER OPTIONS
+	renderDefaults, err := loadRenderDefaults(config.Env())
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// CACHE SERVICE
-	redisConn := fmt.Sprintf("%s:%s",
-		config.Env().Get("REDIS_HOST"),
-		config.Env().Get("REDIS_PORT"),
+	var redisAddrs []string
+	for _, redisHost := range strings.Split(config.Env().Get("REDIS_HOST"), ",") {
+		host, port := strings.TrimSpace(redisHost), config.Env().Get("REDIS_PORT")
+		if h, p, err := net.SplitHostPort(host); err == nil {
+			host, port = h, p
+		}
+		redisAddrs = append(redisAddrs, net.JoinHostPort(host, port))
+	}
+	redisDB, err := strconv.Atoi(config.Env().Get("REDIS_DB"))
+	if err != nil {
+		log.Fatalf("invalid REDIS_DB: %s", err)
+	}
+	redisTLS, err := strconv.ParseBool(config.Env().Get("REDIS_TLS"))
+	if err != nil {
+		log.Fatalf("invalid REDIS_TLS: %s", err)
+	}
+	redisCluster, err := strconv.ParseBool(config.Env().Get("REDIS_CLUSTER"))
+	if err != nil {
+		log.Fatalf("invalid REDIS_CLUSTER: %s", err)
+	}
+	redisClient, err := redis.NewClient(redis.Options{
+		URL:              config.Env().Get("REDIS_URL"),
+		Addrs:            redisAddrs,
+		Username:         config.Env().Get("REDIS_USERNAME"),
+		Password:         config.Env().Get("REDIS_PASSWORD"),
+		DB:               redisDB,
+		TLS:              redisTLS,
+		TLSCACert:        config.Env().Get("REDIS_TLS_CA_CERT"),
+		SentinelMaster:   config.Env().Get("REDIS_SENTINEL_MASTER"),
+		SentinelPassword: config.Env().Get("REDIS_SENTINEL_PASSWORD"),
+		Cluster:          redisCluster,
+	})
+	if err != nil {
+		log.Fatalf("invalid redis configuration: %s", err)
+	}
+	redisClient = redisClient.WithKeyspace(
+		config.Env().Get("CACHE_KEY_PREFIX"),
+		web.RenderNamespace(renderDefaults),
 	)
+	log.Printf("caching renderings in namespace %s\n", redisClient.Namespace())
+	breakerThreshold, err := strconv.Atoi(config.Env().Get("CACHE_BREAKER_THRESHOLD"))
+	if err != nil {
+		log.Fatalf("invalid CACHE_BREAKER_THRESHOLD: %s", err)
+	}
+	breakerCooldown, err := time.ParseDuration(config.Env().Get("CACHE_BREAKER_COOLDOWN"))
+	if err != nil {
+		log.Fatalf("invalid CACHE_BREAKER_COOLDOWN duration format: %s", err)
+	}
+	fallbackSize, err := strconv.Atoi(config.Env().Get("CACHE_FALLBACK_SIZE"))
+	if err != nil {
+		log.Fatalf("invalid CACHE_FALLBACK_SIZE: %s", err)
+	}
+	fallbackCache := memory.NewClient(fallbackSize, 0)
+	localCaches := []web.PostCacheAdapter{fallbackCache}
+	postCacheBreaker := web.NewCircuitBreakerPostCacheAdapter(
+		redisClient,
+		fallbackCache,
+		breakerThreshold,
+		breakerCooldown,
+	)
+	var postCacheAdapter web.PostCacheAdapter = postCacheBreaker
+	l1Size, err := strconv.Atoi(config.Env().Get("CACHE_L1_SIZE"))
+	if err != nil {
+		log.Fatalf("invalid CACHE_L1_SIZE: %s", err)
+	}
+	if l1Size > 0 {
+		l1TTL, err := time.ParseDuration(config.Env().Get("CACHE_L1_TTL"))
+		if err != nil {
+			log.Fatalf("invalid CACHE_L1_TTL duration format: %s", err)
+		}
+		l1Cache := memory.NewClient(l1Size, l1TTL)
+		localCaches = append(localCaches, l1Cache)
+		postCacheAdapter = web.NewTwoLevelPostCacheAdapter(
+			l1Cache,
+			postCacheBreaker,
+			l1TTL,
+		)
+	}
+	var postInvalidationAdapter web.PostInvalidationAdapter
+	switch config.Env().Get("CACHE_INVALIDATION") {
+	case "redis":
+		postInvalidationAdapter = web.NewCircuitBreakerInvalidationAdapter(redisClient, postCacheBreaker)
+	case "postgres":
+		postInvalidationAdapter = post.NewNotifier(db, postgresInfo(
+			config.Env().Get("PG_HOST"),
+			config.Env().Get("PG_PORT"),
+		))
+	case "":
+	default:
+		log.Fatalf("invalid CACHE_INVALIDATION: %q", config.Env().Get("CACHE_INVALIDATION"))
+	}
+	if postInvalidationAdapter != nil {
+		postInvalidationService := web.NewPostInvalidationService(postInvalidationAdapter, localCaches...)
+		go postInvalidationService.Run(ctx)
+		postCacheAdapter = web.NewInvalidatingPostCacheAdapter(postCacheAdapter, postInvalidationService)
+	}
 	postCacheService := web.NewPostCacheService(
-		redis.NewClient(redisConn),
+		postCacheAdapter,
 	)
 	if err := postCacheService.Ping(ctx); err != nil {
 		log.Printf("[WARNING] failed to ping cache service (redis). Skalogram will run as degraded mode: %s\n", err.Error())
 	}
 
+	// VOTE SERVICE
+	voteBatchMaxAttempts, err := strconv.Atoi(config.Env().Get("VOTE_BATCH_MAX_ATTEMPTS"))
+	if err != nil {
+		log.Fatalf("invalid VOTE_BATCH_MAX_ATTEMPTS: %s", err)
+	}
+	postVoteService, err := web.NewPostVoteService(web.NewPostVoteServiceArgs{
+		Mode:                web.VoteMode(config.Env().Get("VOTE_MODE")),
+		PostDatabaseService: postDatabaseService,
+		Buffer:              web.NewCircuitBreakerVoteBufferAdapter(redisClient, postCacheBreaker),
+		MaxBatchAttempts:    voteBatchMaxAttempts,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	voteFlushInterval, err := time.ParseDuration(config.Env().Get("VOTE_FLUSH_INTERVAL"))
+	if err != nil {
+		log.Fatalf("invalid VOTE_FLUSH_INTERVAL duration format: %s", err)
+	}
+	go postVoteService.RunFlusher(ctx, voteFlushInterval)
+
 	// STORAGE SERVICE
 	var postStorageService *web.PostStorageService
 
@@ -83,6 +258,119 @@ func main() {
 		log.Fatal("no storage type configured")
 	}
 
+	// RENDER SERVICE
+	cacheTTL, err := time.ParseDuration(config.Env().Get("CACHE_TTL"))
+	if err != nil {
+		log.Fatalf("invalid CACHE_TTL duration format: %s", err)
+	}
+	cacheLockTTL, err := time.ParseDuration(config.Env().Get("CACHE_LOCK_TTL"))
+	if err != nil {
+		log.Fatalf("invalid CACHE_LOCK_TTL duration format: %s", err)
+	}
+	cacheLockWait, err := time.ParseDuration(config.Env().Get("CACHE_LOCK_WAIT"))
+	if err != nil {
+		log.Fatalf("invalid CACHE_LOCK_WAIT duration format: %s", err)
+	}
+	cacheEarlyRefreshBeta, err := strconv.ParseFloat(config.Env().Get("CACHE_EARLY_REFRESH_BETA"), 64)
+	if err != nil {
+		log.Fatalf("invalid CACHE_EARLY_REFRESH_BETA: %s", err)
+	}
+	renderQueueMaxLength, err := strconv.Atoi(config.Env().Get("RENDER_QUEUE_MAX_LENGTH"))
+	if err != nil || renderQueueMaxLength < 1 {
+		log.Fatalf("invalid RENDER_QUEUE_MAX_LENGTH: %q", config.Env().Get("RENDER_QUEUE_MAX_LENGTH"))
+	}
+	var renderQueue web.RenderQueueAdapter
+	switch config.Env().Get("RENDER_QUEUE") {
+	case "redis":
+		renderQueue = web.NewCircuitBreakerRenderQueueAdapter(redisClient, postCacheBreaker)
+	case "memory":
+		renderQueue = memory.NewRenderQueue(renderQueueMaxLength)
+	default:
+		log.Fatalf("invalid RENDER_QUEUE: %q", config.Env().Get("RENDER_QUEUE"))
+	}
+	renderQueuePendingTTL, err := time.ParseDuration(config.Env().Get("RENDER_QUEUE_PENDING_TTL"))
+	if err != nil {
+		log.Fatalf("invalid RENDER_QUEUE_PENDING_TTL duration format: %s", err)
+	}
+	renderFailureService := web.NewRenderFailureService(store)
+	postRenderService := web.NewPostRenderService(web.NewPostRenderServiceArgs{
+		PostCacheService:   postCacheService,
+		PostStorageService: postStorageService,
+		Lock:               web.NewCircuitBreakerLockAdapter(redisClient, postCacheBreaker),
+		Queue:              renderQueue,
+		QueuePendingTTL:    renderQueuePendingTTL,
+		QueueMaxLength:     renderQueueMaxLength,
+		Failures:           renderFailureService,
+		CacheTTL:           cacheTTL,
+		LockTTL:            cacheLockTTL,
+		LockWait:           cacheLockWait,
+		EarlyRefreshBeta:   cacheEarlyRefreshBeta,
+		Defaults:           renderDefaults,
+	})
+
+	// RENDER WORKERS
+	renderWorkers, err := strconv.Atoi(config.Env().Get("RENDER_WORKERS"))
+	if err != nil {
+		log.Fatalf("invalid RENDER_WORKERS: %s", err)
+	}
+	postRenderWorker := web.NewPostRenderWorker(web.NewPostRenderWorkerArgs{
+		Queue:               renderQueue,
+		PostRenderService:   postRenderService,
+		PostDatabaseService: postDatabaseService,
+		Concurrency:         renderWorkers,
+	})
+	go postRenderWorker.Run(ctx)
+
+	// CACHE WARMER
+	warmInterval, err := time.ParseDuration(config.Env().Get("CACHE_WARM_INTERVAL"))
+	if err != nil {
+		log.Fatalf("invalid CACHE_WARM_INTERVAL duration format: %s", err)
+	}
+	warmRefreshBefore, err := time.ParseDuration(config.Env().Get("CACHE_WARM_REFRESH_BEFORE"))
+	if err != nil {
+		log.Fatalf("invalid CACHE_WARM_REFRESH_BEFORE duration format: %s", err)
+	}
+	warmTopN, err := strconv.Atoi(config.Env().Get("CACHE_WARM_TOP_N"))
+	if err != nil {
+		log.Fatalf("invalid CACHE_WARM_TOP_N: %s", err)
+	}
+	postCacheWarmer := web.NewPostCacheWarmer(web.NewPostCacheWarmerArgs{
+		PostDatabaseService: postDatabaseService,
+		PostCacheService:    postCacheService,
+		PostRenderService:   postRenderService,
+		Interval:            warmInterval,
+		TopN:                warmTopN,
+		RefreshBefore:       warmRefreshBefore,
+	})
+	go postCacheWarmer.Run(ctx)
+
+	// OUTBOX WORKER
+	outboxPollInterval, err := time.ParseDuration(config.Env().Get("OUTBOX_POLL_INTERVAL"))
+	if err != nil {
+		log.Fatalf("invalid OUTBOX_POLL_INTERVAL duration format: %s", err)
+	}
+	outboxPendingTimeout, err := time.ParseDuration(config.Env().Get("OUTBOX_PENDING_TIMEOUT"))
+	if err != nil {
+		log.Fatalf("invalid OUTBOX_PENDING_TIMEOUT duration format: %s", err)
+	}
+	outboxWorker := web.NewPostOutboxWorker(web.NewPostOutboxWorkerArgs{
+		PostDatabaseService: postDatabaseService,
+		PostStorageService:  postStorageService,
+		PollInterval:        outboxPollInterval,
+		PendingTimeout:      outboxPendingTimeout,
+	})
+	go outboxWorker.Run(ctx)
+
+	transcodeUploads, err := strconv.ParseBool(config.Env().Get("UPLOAD_TRANSCODE"))
+	if err != nil {
+		log.Fatalf("invalid UPLOAD_TRANSCODE: %s", err)
+	}
+
+	trustForwardedFor, err := strconv.ParseBool(config.Env().Get("HTTP_TRUST_FORWARDED_FOR"))
+	if err != nil {
+		log.Fatalf("invalid HTTP_TRUST_FORWARDED_FOR: %s", err)
+	}
+
 	listenAddr := fmt.Sprintf("%s:%s",
 		config.Env().Get("LISTEN_ADDR"),
 		config.Env().Get("LISTEN_PORT"),
@@ -91,7 +379,60 @@ func main() {
 		ListenAddr:          listenAddr,
 		PostDatabaseService: postDatabaseService,
 		PostCacheService:    postCacheService,
+		PostVoteService:     postVoteService,
 		PostStorageService:  postStorageService,
+		PostRenderService:   postRenderService,
+		PostCacheWarmer:     postCacheWarmer,
+		PostCacheBreaker:    postCacheBreaker,
+		PostCacheNamespaces: redisClient,
+		RenderFailures:      renderFailureService,
+		ReportService:       reportService,
+		UserService:         userService,
+		TranscodeUploads:    transcodeUploads,
+		TrustForwardedFor:   trustForwardedFor,
 	})
 	server.Run()
 }
+
+// loadRenderDefaults reads the default render options from the RENDER_*
+// variables of env.
+func loadRenderDefaults(env *config.Environ) (render.Options, error) {
+	var err error
+	opts := render.Options{
+		Mode:     render.Mode(env.Get("RENDER_MODE")),
+		Ramp:     env.Get("RENDER_RAMP"),
+		Pipeline: env.Get("RENDER_PIPELINE"),
+	}
+	if opts.Width, err = strconv.Atoi(env.Get("RENDER_WIDTH")); err != nil {
+		return render.Options{}, fmt.Errorf("invalid RENDER_WIDTH: %w", err)
+	}
+	if opts.Height, err = strconv.Atoi(env.Get("RENDER_HEIGHT")); err != nil {
+		return render.Options{}, fmt.Errorf("invalid RENDER_HEIGHT: %w", err)
+	}
+	if opts.Ratio, err = strconv.ParseFloat(env.Get("RENDER_RATIO"), 64); err != nil {
+		return render.Options{}, fmt.Errorf("invalid RENDER_RATIO: %w", err)
+	}
+	if opts.Color, err = strconv.ParseBool(env.Get("RENDER_COLOR")); err != nil {
+		return render.Options{}, fmt.Errorf("invalid RENDER_COLOR: %w", err)
+	}
+	if opts.Invert, err = strconv.ParseBool(env.Get("RENDER_INVERT")); err != nil {
+		return render.Options{}, fmt.Errorf("invalid RENDER_INVERT: %w", err)
+	}
+	if opts.Reversed, err = strconv.ParseBool(env.Get("RENDER_REVERSED")); err != nil {
+		return render.Options{}, fmt.Errorf("invalid RENDER_REVERSED: %w", err)
+	}
+	if err := opts.Validate(); err != nil {
+		return render.Options{}, err
+	}
+	return opts, nil
+}
+
+func postgresInfo(host, port string) string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		host,
+		port,
+		config.Env().Get("PG_USER"),
+		config.Env().Get("PG_PASSWORD"),
+		config.Env().Get("PG_DBNAME"),
+	)
+}
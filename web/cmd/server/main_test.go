@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skale-5/skalogram/render"
+	"github.com/skale-5/skalogram/web/config"
+)
+
+// unsetRenderEnv clears the RENDER_* variables for the duration of the test.
+func unsetRenderEnv(t *testing.T) {
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(key, "RENDER_") {
+			t.Setenv(key, "")
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestLoadRenderDefaults(t *testing.T) {
+	unsetRenderEnv(t)
+	opts, err := loadRenderDefaults(config.Env())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opts, render.DefaultOptions()) {
+		t.Errorf("got %+v, want render.DefaultOptions() %+v", opts, render.DefaultOptions())
+	}
+}
+
+func TestLoadRenderDefaultsEnv(t *testing.T) {
+	unsetRenderEnv(t)
+	t.Setenv("RENDER_MODE", "halfblock")
+	t.Setenv("RENDER_WIDTH", "80")
+	opts, err := loadRenderDefaults(config.Env())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Mode != render.ModeHalfBlock || opts.Width != 80 {
+		t.Errorf("got mode %s and width %d, want halfblock and 80", opts.Mode, opts.Width)
+	}
+
+	t.Setenv("RENDER_WIDTH", "wide")
+	if _, err := loadRenderDefaults(config.Env()); err == nil {
+		t.Error("got no error for an invalid RENDER_WIDTH")
+	}
+	t.Setenv("RENDER_WIDTH", "1000")
+	if _, err := loadRenderDefaults(config.Env()); err == nil {
+		t.Error("got no error for a RENDER_WIDTH out of bounds")
+	}
+}
@@ -13,16 +13,80 @@ func defaults() map[string]string {
 		"PG_PASSWORD": "postgres",
 		"PG_DBNAME":   "skalogram",
 
+		"PG_REPLICA_HOSTS":           "",
+		"PG_REPLICA_HEALTH_INTERVAL": "10s",
+
 		"REDIS_HOST": "127.0.0.1",
 		"REDIS_PORT": "6379",
 		"CACHE_TTL":  "60s",
 
+		"REDIS_URL":               "",
+		"REDIS_USERNAME":          "",
+		"REDIS_PASSWORD":          "",
+		"REDIS_DB":                "0",
+		"REDIS_TLS":               "false",
+		"REDIS_TLS_CA_CERT":       "",
+		"REDIS_SENTINEL_MASTER":   "",
+		"REDIS_SENTINEL_PASSWORD": "",
+		"REDIS_CLUSTER":           "false",
+
+		"CACHE_KEY_PREFIX": "skalogram",
+
+		"CACHE_LOCK_TTL":           "10s",
+		"CACHE_LOCK_WAIT":          "5s",
+		"CACHE_EARLY_REFRESH_BETA": "1",
+
+		"CACHE_BREAKER_THRESHOLD": "5",
+		"CACHE_BREAKER_COOLDOWN":  "30s",
+		"CACHE_FALLBACK_SIZE":     "1000",
+
+		"CACHE_L1_SIZE": "1000",
+		"CACHE_L1_TTL":  "5s",
+
+		"CACHE_INVALIDATION": "redis",
+
+		"CACHE_WARM_INTERVAL":       "15s",
+		"CACHE_WARM_REFRESH_BEFORE": "20s",
+		"CACHE_WARM_TOP_N":          "20",
+
+		"RENDER_MODE":     "ascii",
+		"RENDER_PIPELINE": "",
+		"RENDER_WIDTH":    "55",
+		"RENDER_HEIGHT":   "20",
+		"RENDER_RATIO":    "2",
+		"RENDER_COLOR":    "true",
+		"RENDER_RAMP":     " .,:;i1tfLCG08@",
+		"RENDER_INVERT":   "false",
+		"RENDER_REVERSED": "false",
+
+		"RENDER_QUEUE":             "redis",
+		"RENDER_QUEUE_PENDING_TTL": "1m",
+		"RENDER_QUEUE_MAX_LENGTH":  "1000",
+		"RENDER_WORKERS":           "2",
+
 		"STORAGE_TYPE":          "gs",
 		"STORAGE_BUCKET":        "skalogram-posts-dev",
 		"STORAGE_BUCKET_REGION": "eu-west3",
 
+		"UPLOAD_TRANSCODE": "false",
+
+		"VOTE_MODE":               "sync",
+		"VOTE_FLUSH_INTERVAL":     "5s",
+		"VOTE_BATCH_MAX_ATTEMPTS": "10",
+
+		"OUTBOX_POLL_INTERVAL":   "10s",
+		"OUTBOX_PENDING_TIMEOUT": "5m",
+
+		"REPORT_HIDE_THRESHOLD": "3",
+		"REPORT_RATE_LIMIT":     "10",
+
+		"ADMIN_USER":     "admin",
+		"ADMIN_PASSWORD": "",
+
 		"LISTEN_ADDR": "0.0.0.0",
 		"LISTEN_PORT": "8080",
+
+		"HTTP_TRUST_FORWARDED_FOR": "false",
 	}
 }
 
@@ -0,0 +1,472 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/render"
+	"github.com/skale-5/skalogram/web"
+	"github.com/skale-5/skalogram/web/delivery/http/templates"
+)
+
+type userKey struct{}
+
+// requireRole protects h with basic auth credentials of a staff account
+// holding at least role.
+func (s *Server) requireRole(role web.Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="skalogram admin"`)
+			httpError(w, http.StatusUnauthorized, "unauthorized", fmt.Errorf("missing credentials"))
+			return
+		}
+		user, err := s.userService.Authenticate(r.Context(), name, password)
+		if errors.Is(err, web.ErrInvalidCredentials) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="skalogram admin"`)
+			httpError(w, http.StatusUnauthorized, "unauthorized", fmt.Errorf("invalid credentials for user %q", name))
+			return
+		}
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "server error", err)
+			return
+		}
+		if !user.Role.Can(role) {
+			httpError(w, http.StatusForbidden, "forbidden", fmt.Errorf("user %q with role %s requires role %s", user.Name, user.Role, role))
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+			httpError(w, http.StatusForbidden, "forbidden", fmt.Errorf("cross-origin %s request of user %q", r.Method, user.Name))
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	}
+}
+
+// sameOrigin tells whether a request comes from a page of this server,
+// from its Origin header or else its Referer: browsers send the basic auth
+// credentials of the staff with the forms of any other site.
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Referer()
+	}
+	if source == "" {
+		return false
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
+
+// rejectBanned refuses the request of banned visitors.
+func (s *Server) rejectBanned(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := s.clientIP(r)
+		banned, err := s.userService.IsBanned(r.Context(), ip)
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "server error", err)
+			return
+		}
+		if banned {
+			httpError(w, http.StatusForbidden, "you are banned", fmt.Errorf("banned ip %s", ip))
+			return
+		}
+		h(w, r)
+	}
+}
+
+func currentUser(r *http.Request) web.User {
+	user, _ := r.Context().Value(userKey{}).(web.User)
+	return user
+}
+
+func moderatorID(r *http.Request) string {
+	return currentUser(r).Name
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed", fmt.Errorf("method %s not allowed", r.Method))
+		return false
+	}
+	return true
+}
+
+func (s *Server) adminPostsHandler(w http.ResponseWriter, r *http.Request) {
+	status := web.PostStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = web.PostStatusPublished
+	}
+	if !status.Moderable() {
+		httpError(w, http.StatusBadRequest, "invalid status", fmt.Errorf("invalid status %q", status))
+		return
+	}
+	posts, err := s.postDatabaseService.ListPostsByStatus(r.Context(), status)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to list posts", err)
+		return
+	}
+	postsAsciiHTML := make([]template.HTML, len(posts))
+	for i, post := range posts {
+		postsAsciiHTML[i] = s.adminPostAsciiHTML(r, post, s.postRenderService.DefaultOptions())
+	}
+	var cacheNamespaces []web.CacheNamespace
+	if currentUser(r).Role.Can(web.RoleAdmin) && s.postCacheNamespaces != nil {
+		cacheNamespaces, err = s.postCacheNamespaces.Namespaces(r.Context())
+		if err != nil {
+			log.Printf("[WARNING] failed to list cache namespaces: %s\n", err)
+		}
+	}
+	var deadVotes int64
+	if currentUser(r).Role.Can(web.RoleAdmin) {
+		deadVotes, err = s.postVoteService.DeadVotes(r.Context())
+		if err != nil {
+			log.Printf("[WARNING] failed to count dead votes: %s\n", err)
+		}
+	}
+	err = templates.RenderAdminPosts(w, templates.RenderAdminPostsArgs{
+		User:            currentUser(r),
+		Status:          status,
+		Statuses:        web.PostModerationStatuses,
+		Posts:           posts,
+		PostsAsciiHTML:  postsAsciiHTML,
+		CacheNamespaces: cacheNamespaces,
+		CacheNamespace:  web.RenderNamespace(s.postRenderService.DefaultOptions()),
+		DeadVotes:       deadVotes,
+		AnimationScript: template.JS(render.AnimationScript),
+	})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to render admin posts", err)
+		return
+	}
+}
+
+func (s *Server) adminPostsBulkHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to parse form", err)
+		return
+	}
+	action := r.PostForm.Get("action")
+	reason := r.PostForm.Get("reason")
+	for _, id := range r.PostForm["id"] {
+		uid, err := uuid.Parse(id)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "malformed id params", fmt.Errorf("malformed id params"))
+			return
+		}
+		moderation := web.ModeratePostParams{
+			ID:          uid,
+			Reason:      reason,
+			ModeratorID: moderatorID(r),
+		}
+		switch action {
+		case "hide":
+			err = s.postDatabaseService.SetPostStatus(r.Context(), web.SetPostStatusParams{
+				ID:          uid,
+				Status:      web.PostStatusHidden,
+				Reason:      reason,
+				ModeratorID: moderatorID(r),
+			})
+		case "delete":
+			err = s.postDatabaseService.DeletePost(r.Context(), moderation)
+		case "restore":
+			err = s.postDatabaseService.RestorePost(r.Context(), moderation)
+		default:
+			httpError(w, http.StatusBadRequest, "invalid action", fmt.Errorf("invalid action %q", action))
+			return
+		}
+		if errors.Is(err, web.ErrPostNotFound) {
+			log.Printf("[WARNING] cannot %s post %s: %s\n", action, uid, err)
+			continue
+		}
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "server error", err)
+			return
+		}
+		if err := s.postCacheService.DeletePost(r.Context(), uid); err != nil {
+			log.Printf("[WARNING] failed to evict post %s from cache: %s\n", uid, err)
+		}
+	}
+	http.Redirect(w, r, "/admin?status="+url.QueryEscape(r.PostForm.Get("status")), http.StatusSeeOther)
+}
+
+func (s *Server) adminReportsHandler(w http.ResponseWriter, r *http.Request) {
+	queue, err := s.reportService.Queue(r.Context())
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to list reports", err)
+		return
+	}
+	postsAsciiHTML := make([]template.HTML, len(queue))
+	for i, reported := range queue {
+		postsAsciiHTML[i] = s.adminPostAsciiHTML(r, reported.Post, s.postRenderService.DefaultOptions())
+	}
+	err = templates.RenderReports(w, templates.RenderReportsArgs{
+		User:            currentUser(r),
+		Queue:           queue,
+		PostsAsciiHTML:  postsAsciiHTML,
+		AnimationScript: template.JS(render.AnimationScript),
+	})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to render reports", err)
+		return
+	}
+}
+
+func (s *Server) adminReportsDismissHandler(w http.ResponseWriter, r *http.Request) {
+	s.adminReportsAction(w, r, s.reportService.Dismiss)
+}
+
+func (s *Server) adminReportsRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	s.adminReportsAction(w, r, s.reportService.Remove)
+}
+
+func (s *Server) adminReportsAction(w http.ResponseWriter, r *http.Request, action func(context.Context, uuid.UUID, string) error) {
+	if !requirePost(w, r) {
+		return
+	}
+	uid, err := uuid.Parse(r.PostFormValue("id"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "malformed id params", fmt.Errorf("malformed id params"))
+		return
+	}
+	err = action(r.Context(), uid, moderatorID(r))
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "server error", err)
+		return
+	}
+	if err := s.postCacheService.DeletePost(r.Context(), uid); err != nil {
+		log.Printf("[WARNING] failed to evict post %s from cache: %s\n", uid, err)
+	}
+	http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+}
+
+func (s *Server) adminBansHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		ip := r.PostFormValue("ip")
+		if net.ParseIP(ip) == nil {
+			httpError(w, http.StatusBadRequest, "malformed ip params", fmt.Errorf("malformed ip param %q", ip))
+			return
+		}
+		var purgeVoter string
+		if r.PostFormValue("purge_votes") == "on" {
+			purgeVoter = r.PostFormValue("visitor")
+		}
+		err := s.userService.Ban(r.Context(), web.Ban{
+			IP:          ip,
+			Reason:      r.PostFormValue("reason"),
+			ModeratorID: moderatorID(r),
+		}, purgeVoter)
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "server error", err)
+			return
+		}
+		http.Redirect(w, r, "/admin/bans", http.StatusSeeOther)
+		return
+	}
+
+	bans, err := s.userService.ListBans(r.Context())
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to list bans", err)
+		return
+	}
+	err = templates.RenderAdminBans(w, templates.RenderAdminBansArgs{
+		User:    currentUser(r),
+		Bans:    bans,
+		IP:      r.URL.Query().Get("ip"),
+		Visitor: r.URL.Query().Get("visitor"),
+	})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to render bans", err)
+		return
+	}
+}
+
+func (s *Server) adminBansDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	err := s.userService.Unban(r.Context(), r.PostFormValue("ip"))
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "server error", err)
+		return
+	}
+	http.Redirect(w, r, "/admin/bans", http.StatusSeeOther)
+}
+
+func (s *Server) adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		err := s.userService.SaveUser(r.Context(),
+			r.PostFormValue("name"),
+			r.PostFormValue("password"),
+			web.Role(r.PostFormValue("role")),
+		)
+		if errors.Is(err, web.ErrInvalidRole) || errors.Is(err, web.ErrInvalidCredentials) {
+			httpError(w, http.StatusBadRequest, "invalid user", err)
+			return
+		}
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "server error", err)
+			return
+		}
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	users, err := s.userService.ListUsers(r.Context())
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to list users", err)
+		return
+	}
+	err = templates.RenderAdminUsers(w, templates.RenderAdminUsersArgs{
+		User:  currentUser(r),
+		Users: users,
+		Roles: web.Roles,
+	})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to render users", err)
+		return
+	}
+}
+
+func (s *Server) adminUsersDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	name := r.PostFormValue("name")
+	if name == moderatorID(r) {
+		httpError(w, http.StatusBadRequest, "cannot delete yourself", fmt.Errorf("user %q tried to delete itself", name))
+		return
+	}
+	err := s.userService.DeleteUser(r.Context(), name)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "server error", err)
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminVotesReplayHandler buffers again the votes set aside after their
+// batch failed to flush too many times.
+func (s *Server) adminVotesReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	n, err := s.postVoteService.ReplayDeadVotes(r.Context())
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to replay dead votes", err)
+		return
+	}
+	log.Printf("replayed %d dead votes\n", n)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// adminCachePurgeHandler evicts a single post from the cache, every post
+// when no id is given, or every entry of a cache namespace.
+func (s *Server) adminCachePurgeHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	if namespace := r.PostFormValue("namespace"); namespace != "" {
+		if s.postCacheNamespaces == nil {
+			httpError(w, http.StatusNotImplemented, "cache namespaces not supported", fmt.Errorf("no cache namespace adapter"))
+			return
+		}
+		n, err := s.postCacheNamespaces.PurgeNamespace(r.Context(), namespace)
+		if errors.Is(err, web.ErrInvalidCacheNamespace) {
+			httpError(w, http.StatusBadRequest, "invalid cache namespace", err)
+			return
+		}
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "failed to purge cache namespace", err)
+			return
+		}
+		log.Printf("purged %d entries of cache namespace %s\n", n, namespace)
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+	var ids []uuid.UUID
+	if id := r.PostFormValue("id"); id != "" {
+		uid, err := uuid.Parse(id)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "malformed id params", fmt.Errorf("malformed id params"))
+			return
+		}
+		ids = append(ids, uid)
+	} else {
+		for _, status := range web.PostModerationStatuses {
+			posts, err := s.postDatabaseService.ListPostsByStatus(r.Context(), status)
+			if err != nil {
+				httpError(w, http.StatusInternalServerError, "failed to list posts", err)
+				return
+			}
+			for _, post := range posts {
+				ids = append(ids, post.ID)
+			}
+		}
+	}
+	for _, id := range ids {
+		if err := s.postCacheService.DeletePost(r.Context(), id); err != nil {
+			httpError(w, http.StatusInternalServerError, "failed to purge cache", err)
+			return
+		}
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (s *Server) adminRenderFailuresHandler(w http.ResponseWriter, r *http.Request) {
+	var failures []web.RenderFailure
+	if s.renderFailures != nil {
+		var err error
+		failures, err = s.renderFailures.List(r.Context())
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "failed to list render failures", err)
+			return
+		}
+	}
+	err := templates.RenderAdminRenderFailures(w, templates.RenderAdminRenderFailuresArgs{
+		User:     currentUser(r),
+		Failures: failures,
+	})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to render render failures", err)
+		return
+	}
+}
+
+func (s *Server) adminRenderFailuresRetryHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	uid, err := uuid.Parse(r.PostFormValue("id"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "malformed id params", fmt.Errorf("malformed id params"))
+		return
+	}
+	post, err := s.postDatabaseService.GetPost(r.Context(), uid)
+	if errors.Is(err, web.ErrPostNotFound) {
+		httpError(w, http.StatusNotFound, "post not found", err)
+		return
+	}
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "server error", err)
+		return
+	}
+	if err := s.postRenderService.Retry(r.Context(), post); err != nil {
+		httpError(w, http.StatusInternalServerError, "server error", err)
+		return
+	}
+	http.Redirect(w, r, "/admin/render-failures", http.StatusSeeOther)
+}
@@ -0,0 +1,315 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/render"
+	"github.com/skale-5/skalogram/web"
+)
+
+// renderSizeStep and renderRatioStep quantize the width, height and ratio
+// overrides, so that close renderings share their cache entries.
+const (
+	renderSizeStep  = 10
+	renderRatioStep = 0.25
+)
+
+// renderOptions returns the options of the preset query parameter, the
+// default ones without it, overridden with the query parameters mode,
+// width, height, ratio, color, ramp, invert, reversed and pipeline.
+func (s *Server) renderOptions(r *http.Request) (render.Options, error) {
+	query := r.URL.Query()
+	opts, err := s.postRenderService.Preset(query.Get("preset"))
+	if err != nil {
+		return opts, err
+	}
+	if v := query.Get("mode"); v != "" {
+		opts.Mode = render.Mode(v)
+	}
+	if v := query.Get("width"); v != "" {
+		width, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("%w: malformed width", render.ErrInvalidOptions)
+		}
+		opts.Width = quantizeSize(width, render.MaxWidth)
+	}
+	if v := query.Get("height"); v != "" {
+		height, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("%w: malformed height", render.ErrInvalidOptions)
+		}
+		opts.Height = quantizeSize(height, render.MaxHeight)
+	}
+	if v := query.Get("ratio"); v != "" {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return opts, fmt.Errorf("%w: malformed ratio", render.ErrInvalidOptions)
+		}
+		opts.Ratio = math.Round(ratio/renderRatioStep) * renderRatioStep
+	}
+	if v := query.Get("color"); v != "" {
+		if opts.Color, err = strconv.ParseBool(v); err != nil {
+			return opts, fmt.Errorf("%w: malformed color", render.ErrInvalidOptions)
+		}
+	}
+	if query.Has("ramp") {
+		opts.Ramp = query.Get("ramp")
+	}
+	if v := query.Get("invert"); v != "" {
+		if opts.Invert, err = strconv.ParseBool(v); err != nil {
+			return opts, fmt.Errorf("%w: malformed invert", render.ErrInvalidOptions)
+		}
+	}
+	if v := query.Get("reversed"); v != "" {
+		if opts.Reversed, err = strconv.ParseBool(v); err != nil {
+			return opts, fmt.Errorf("%w: malformed reversed", render.ErrInvalidOptions)
+		}
+	}
+	if query.Has("pipeline") {
+		opts.Pipeline = query.Get("pipeline")
+	}
+	return opts, opts.Validate()
+}
+
+// quantizeSize rounds a size up to a multiple of renderSizeStep, within
+// max. Sizes out of bounds are kept for Validate to reject them.
+func quantizeSize(size, max int) int {
+	if size < 1 || size > max {
+		return size
+	}
+	size = (size + renderSizeStep - 1) / renderSizeStep * renderSizeStep
+	if size > max {
+		return max
+	}
+	return size
+}
+
+// negotiateFormat picks the format of the first media range of the Accept
+// header matching one, ignoring quality values.
+func negotiateFormat(accept string) (render.Format, bool) {
+	if accept == "" {
+		return render.FormatHTML, true
+	}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaRange, _, _ = strings.Cut(mediaRange, ";")
+		mediaRange = strings.TrimSpace(mediaRange)
+		switch mediaRange {
+		case "*/*", "text/*":
+			return render.FormatHTML, true
+		case "image/*":
+			return render.FormatPNG, true
+		}
+		for _, f := range render.Formats {
+			if f.MediaType() == mediaRange {
+				return f, true
+			}
+		}
+	}
+	return "", false
+}
+
+const (
+	postRenderPath      = "/p/"
+	adminPostRenderPath = "/admin/p/"
+)
+
+// postRenderHandler serves a post rendering at /p/{id}.{ext}, or in the
+// format negotiated from the Accept header at /p/{id}. With the async query
+// parameter, a post missing from the cache is queued and 202 returned.
+func (s *Server) postRenderHandler(w http.ResponseWriter, r *http.Request) {
+	s.servePostRender(w, r, postRenderPath, false)
+}
+
+// adminPostRenderHandler is postRenderHandler at /admin/p/, for the posts of
+// any status.
+func (s *Server) adminPostRenderHandler(w http.ResponseWriter, r *http.Request) {
+	s.servePostRender(w, r, adminPostRenderPath, true)
+}
+
+func (s *Server) servePostRender(w http.ResponseWriter, r *http.Request, path string, anyStatus bool) {
+	name := strings.TrimPrefix(r.URL.Path, path)
+	id, ext, hasExt := strings.Cut(name, ".")
+
+	var format render.Format
+	if hasExt {
+		f, ok := render.FormatFromExtension(ext)
+		if !ok {
+			httpError(w, http.StatusNotFound, "unknown format", fmt.Errorf("unknown format extension %q", ext))
+			return
+		}
+		format = f
+	} else {
+		w.Header().Add("Vary", "Accept")
+		f, ok := negotiateFormat(r.Header.Get("Accept"))
+		if !ok {
+			httpError(w, http.StatusNotAcceptable, "no acceptable format", fmt.Errorf("no format matches %q", r.Header.Get("Accept")))
+			return
+		}
+		format = f
+	}
+
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		httpError(w, http.StatusNotFound, "post not found", err)
+		return
+	}
+	post, err := s.postDatabaseService.GetPost(r.Context(), uid)
+	if err == nil && !anyStatus && post.Status != web.PostStatusPublished {
+		err = web.ErrPostNotFound
+	}
+	if errors.Is(err, web.ErrPostNotFound) {
+		httpError(w, http.StatusNotFound, "post not found", err)
+		return
+	}
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to get post", err)
+		return
+	}
+
+	opts, err := s.renderOptions(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	async, err := asyncRender(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	var canvas *render.Canvas
+	if async {
+		canvas, err = s.postRenderService.CanvasAsync(r.Context(), post, opts)
+	} else {
+		canvas, err = s.postRenderService.Canvas(r.Context(), post, opts)
+	}
+	if errors.Is(err, web.ErrRenderPending) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "rendering")
+		return
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		if web.RenderErrorCategoryOf(err).Permanent() {
+			code = http.StatusUnprocessableEntity
+		}
+		httpError(w, code, renderErrorMessage(web.RenderErrorCategoryOf(err)), err)
+		return
+	}
+
+	if format == render.FormatANSI {
+		palette := render.Palette256
+		if v := r.URL.Query().Get("palette"); v != "" {
+			palette = render.Palette(v)
+		}
+		if !palette.Valid() {
+			err := fmt.Errorf("unknown palette %q", palette)
+			httpError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		loops, err := animationLoops(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		w.Header().Set("Content-Type", format.ContentType())
+		ctx, cancel := context.WithTimeout(r.Context(), maxAnimationPlayback)
+		defer cancel()
+		if err := canvas.PlayANSI(ctx, w, loops, palette); err != nil && ctx.Err() == nil {
+			log.Printf("[WARNING] failed to write post %s ansi: %s\n", post.ID, err)
+		}
+		return
+	}
+
+	output, err := canvas.Encode(format)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to encode post", err)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Write(output)
+}
+
+// maxAnimationLoops and maxAnimationPlayback bound how long an ANSI animation
+// holds the connection, the playback stopping after maxAnimationPlayback
+// whatever the loops.
+const (
+	maxAnimationLoops    = 20
+	maxAnimationPlayback = time.Minute
+)
+
+// animationLoops reads how many times an ANSI animation is played from the
+// loops query parameter, once by default.
+func animationLoops(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("loops")
+	if v == "" {
+		return 1, nil
+	}
+	loops, err := strconv.Atoi(v)
+	if err != nil || loops < 1 || loops > maxAnimationLoops {
+		return 0, fmt.Errorf("loops must be between 1 and %d", maxAnimationLoops)
+	}
+	return loops, nil
+}
+
+// asyncRender reads the async query parameter.
+func asyncRender(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("async")
+	if v == "" {
+		return false, nil
+	}
+	async, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("malformed async")
+	}
+	return async, nil
+}
+
+// postAsciiHTML renders a post for the pages, a placeholder polling its
+// async rendering when it is queued, or a placeholder telling why it
+// failed, so that a broken post does not break the whole page.
+func (s *Server) postAsciiHTML(r *http.Request, post web.Post, opts render.Options) template.HTML {
+	return s.renderPostAsciiHTML(r, post, opts, postRenderPath)
+}
+
+// adminPostAsciiHTML is postAsciiHTML for the posts of any status, their
+// placeholders being replaced from /admin/p/.
+func (s *Server) adminPostAsciiHTML(r *http.Request, post web.Post, opts render.Options) template.HTML {
+	return s.renderPostAsciiHTML(r, post, opts, adminPostRenderPath)
+}
+
+func (s *Server) renderPostAsciiHTML(r *http.Request, post web.Post, opts render.Options, path string) template.HTML {
+	ascii, err := s.postRenderService.Ascii(r.Context(), post, opts)
+	if err != nil && !errors.Is(err, web.ErrRenderPending) && !web.RenderErrorCategoryOf(err).Permanent() {
+		// transient failures are rendered again when the placeholder polls
+		log.Printf("[WARNING] failed to render post %s: %s\n", post.ID, err)
+		err = web.ErrRenderPending
+	}
+	if errors.Is(err, web.ErrRenderPending) {
+		query := r.URL.Query()
+		query.Set("async", "true")
+		src := path + post.ID.String() + ".html?" + query.Encode()
+		return template.HTML(`<span data-pending="` + html.EscapeString(src) + `">rendering…</span>`)
+	}
+	if err != nil {
+		log.Printf("[WARNING] failed to render post %s: %s\n", post.ID, err)
+		category := web.RenderErrorCategoryOf(err)
+		return template.HTML(`<span data-render-error="` + html.EscapeString(string(category)) + `">` +
+			html.EscapeString(renderErrorMessage(category)) + `</span>`)
+	}
+	return template.HTML(ascii)
+}
+
+func renderErrorMessage(category web.RenderErrorCategory) string {
+	return "cannot render post: " + strings.ReplaceAll(string(category), "_", " ")
+}
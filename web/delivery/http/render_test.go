@@ -0,0 +1,53 @@
+package http
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skale-5/skalogram/render"
+	"github.com/skale-5/skalogram/web"
+)
+
+func TestRenderOptions(t *testing.T) {
+	defaults := render.DefaultOptions()
+	s := &Server{postRenderService: web.NewPostRenderService(web.NewPostRenderServiceArgs{Defaults: defaults})}
+	tests := []struct {
+		query  string
+		modify func(o *render.Options)
+		err    error
+	}{
+		{"", func(o *render.Options) {}, nil},
+		{"width=81&height=1", func(o *render.Options) { o.Width, o.Height = 90, 10 }, nil},
+		{"width=199", func(o *render.Options) { o.Width = render.MaxWidth }, nil},
+		{"ratio=1.1", func(o *render.Options) { o.Ratio = 1 }, nil},
+		{"color=false&invert=true&reversed=1", func(o *render.Options) { o.Color, o.Invert, o.Reversed = false, true, true }, nil},
+		{"ramp=%20.%40&pipeline=gamma:2", func(o *render.Options) { o.Ramp, o.Pipeline = " .@", "gamma:2" }, nil},
+		{"preset=braille&width=20", func(o *render.Options) { o.Mode, o.Width = render.ModeBraille, 20 }, nil},
+		{"preset=sixel", nil, web.ErrUnknownRenderPreset},
+		{"width=0", nil, render.ErrInvalidOptions},
+		{"width=201", nil, render.ErrInvalidOptions},
+		{"height=tall", nil, render.ErrInvalidOptions},
+		{"ratio=NaN", nil, render.ErrInvalidOptions},
+		{"color=maybe", nil, render.ErrInvalidOptions},
+		{"ramp=@", nil, render.ErrInvalidOptions},
+		{"mode=sixel", nil, render.ErrInvalidOptions},
+		{"pipeline=blur:2", nil, render.ErrInvalidOptions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			opts, err := s.renderOptions(httptest.NewRequest("GET", "/?"+tt.query, nil))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("got %v, want %v", err, tt.err)
+				}
+				return
+			}
+			want := defaults
+			tt.modify(&want)
+			if err != nil || opts != want {
+				t.Errorf("got %+v, %v, want %+v", opts, err, want)
+			}
+		})
+	}
+}
@@ -1,13 +1,20 @@
 package http
 
 import (
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/render"
 	"github.com/skale-5/skalogram/web"
 	"github.com/skale-5/skalogram/web/config"
 	"github.com/skale-5/skalogram/web/delivery/http/templates"
@@ -17,14 +24,37 @@ type Server struct {
 	listenAddr          string
 	postDatabaseService *web.PostDatabaseService
 	postCacheService    *web.PostCacheService
+	postVoteService     *web.PostVoteService
 	postStorageService  *web.PostStorageService
+	postRenderService   *web.PostRenderService
+	postCacheWarmer     *web.PostCacheWarmer
+	postCacheBreaker    *web.CircuitBreakerPostCacheAdapter
+	postCacheNamespaces web.PostCacheNamespaceAdapter
+	renderFailures      *web.RenderFailureService
+	reportService       *web.ReportService
+	userService         *web.UserService
+	transcodeUploads    bool
+	trustForwardedFor   bool
 }
 
 type NewServerArgs struct {
 	ListenAddr          string
 	PostDatabaseService *web.PostDatabaseService
 	PostCacheService    *web.PostCacheService
+	PostVoteService     *web.PostVoteService
 	PostStorageService  *web.PostStorageService
+	PostRenderService   *web.PostRenderService
+	PostCacheWarmer     *web.PostCacheWarmer
+	PostCacheBreaker    *web.CircuitBreakerPostCacheAdapter
+	PostCacheNamespaces web.PostCacheNamespaceAdapter
+	RenderFailures      *web.RenderFailureService
+	ReportService       *web.ReportService
+	UserService         *web.UserService
+	// TranscodeUploads stores the WebP, BMP and TIFF uploads as PNG.
+	TranscodeUploads bool
+	// TrustForwardedFor reads the client IP from the X-Forwarded-For header
+	// set by a reverse proxy.
+	TrustForwardedFor bool
 }
 
 func NewServer(args NewServerArgs) *Server {
@@ -32,7 +62,17 @@ func NewServer(args NewServerArgs) *Server {
 		listenAddr:          args.ListenAddr,
 		postDatabaseService: args.PostDatabaseService,
 		postCacheService:    args.PostCacheService,
+		postVoteService:     args.PostVoteService,
 		postStorageService:  args.PostStorageService,
+		postRenderService:   args.PostRenderService,
+		postCacheWarmer:     args.PostCacheWarmer,
+		postCacheBreaker:    args.PostCacheBreaker,
+		postCacheNamespaces: args.PostCacheNamespaces,
+		renderFailures:      args.RenderFailures,
+		reportService:       args.ReportService,
+		userService:         args.UserService,
+		transcodeUploads:    args.TranscodeUploads,
+		trustForwardedFor:   args.TrustForwardedFor,
 	}
 }
 
@@ -42,6 +82,83 @@ func httpError(w http.ResponseWriter, code int, message string, err error) {
 	fmt.Fprint(w, message)
 }
 
+const visitorCookieName = "skalogram_visitor"
+
+const (
+	readPrimaryCookieName = "skalogram_read_primary"
+	// readPrimaryDuration outlasts the replication lag of the replicas.
+	readPrimaryDuration = 10 * time.Second
+)
+
+// readPrimaryAfterWrite sends the next requests of the client to the
+// primary database for a while, so that the client reads its own write.
+func readPrimaryAfterWrite(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     readPrimaryCookieName,
+		Value:    "1",
+		Path:     "/",
+		MaxAge:   int(readPrimaryDuration.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// readYourWrites sends the reads of the admin pages and of the clients
+// which just wrote to the primary database.
+func readYourWrites(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := r.Cookie(readPrimaryCookieName)
+		if err == nil || r.URL.Path == "/admin" || strings.HasPrefix(r.URL.Path, "/admin/") {
+			r = r.WithContext(web.ReadPrimary(r.Context()))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// visitorID returns the anonymous identity of the client, issuing a new one
+// through a cookie on first visit.
+func visitorID(w http.ResponseWriter, r *http.Request) string {
+	cookie, err := r.Cookie(visitorCookieName)
+	if err == nil {
+		if _, err := uuid.Parse(cookie.Value); err == nil {
+			return cookie.Value
+		}
+	}
+	// the identity may already have been issued earlier in this request
+	issued := (&http.Response{Header: w.Header()}).Cookies()
+	for _, cookie := range issued {
+		if cookie.Name == visitorCookieName {
+			return cookie.Value
+		}
+	}
+	id := uuid.New().String()
+	http.SetCookie(w, &http.Cookie{
+		Name:     visitorCookieName,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// clientIP returns the IP address of the client, the last one appended to
+// X-Forwarded-For by the reverse proxy when it is trusted.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.trustForwardedFor {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (s *Server) voidHandler(w http.ResponseWriter, r *http.Request) {}
 
 func (s *Server) postsUploadHandler(w http.ResponseWriter, r *http.Request) {
@@ -60,16 +177,25 @@ func (s *Server) postsUploadHandler(w http.ResponseWriter, r *http.Request) {
 
 	id := uuid.New()
 
-	allowedContentType := map[string]bool{
-		"image/png":  true,
-		"image/jpeg": true,
-	}
-
-	if !allowedContentType[h.Header.Get("Content-Type")] {
-		err = fmt.Errorf("unauthorized file format: %s", h.Header.Get("Content-Type"))
+	imgConfig, format, err := web.SniffImage(f)
+	if err != nil {
+		err = fmt.Errorf("unauthorized file %s declared as %s: %w", h.Filename, h.Header.Get("Content-Type"), err)
 		httpError(w, http.StatusBadRequest, "file format not allowed", err)
 		return
 	}
+	// decompression bombs are rejected before being stored or decoded
+	if err := render.CheckSize(imgConfig.Width, imgConfig.Height); err != nil {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("image larger than %d pixels", render.MaxPixels), err)
+		return
+	}
+	var content io.Reader = f
+	if s.transcodeUploads {
+		content, err = web.TranscodeImage(f, format)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "failed to transcode image", err)
+			return
+		}
+	}
 
 	fullObjectPath := fmt.Sprintf("%s://%s/%s",
 		config.Env().Get("STORAGE_TYPE"),
@@ -81,20 +207,49 @@ func (s *Server) postsUploadHandler(w http.ResponseWriter, r *http.Request) {
 		httpError(w, http.StatusInternalServerError, "failed to create object path", err)
 		return
 	}
-	err = s.postStorageService.Write(r.Context(), object, f)
+	params := web.CreatePostParams{
+		ID:       id,
+		ImgUrl:   object.URL(),
+		Author:   visitorID(w, r),
+		AuthorIP: s.clientIP(r),
+	}
+	err = s.postDatabaseService.CreatePendingPost(r.Context(), params)
 	if err != nil {
-		httpError(w, http.StatusBadRequest, "failed to upload object", err)
+		httpError(w, http.StatusInternalServerError, "failed to create post", err)
 		return
 	}
 
-	err = s.postDatabaseService.CreatePost(r.Context(), web.CreatePostParams{
-		ID:     id,
-		ImgUrl: object.URL(),
-	})
+	err = s.postStorageService.Write(r.Context(), object, content)
 	if err != nil {
-		httpError(w, http.StatusInternalServerError, "failed to create post", err)
+		if err := s.postStorageService.Delete(r.Context(), object); err != nil {
+			log.Printf("[WARNING] failed to delete object of post %s, the outbox worker will retry: %s\n", id, err)
+		} else if err := s.postDatabaseService.DiscardPost(r.Context(), id); err != nil {
+			log.Printf("[WARNING] failed to discard post %s, the outbox worker will retry: %s\n", id, err)
+		}
+		httpError(w, http.StatusBadRequest, "failed to upload object", err)
 		return
 	}
+
+	err = s.postDatabaseService.PublishPost(r.Context(), id)
+	if errors.Is(err, web.ErrPostNotFound) {
+		// the outbox worker discarded the post while its upload was too slow
+		if err := s.postStorageService.Delete(r.Context(), object); err != nil {
+			log.Printf("[WARNING] failed to delete object of discarded post %s: %s\n", id, err)
+		}
+		httpError(w, http.StatusInternalServerError, "upload took too long, post discarded", err)
+		return
+	}
+	if err != nil {
+		log.Printf("[WARNING] failed to publish post %s, the outbox worker will retry: %s\n", id, err)
+	} else if s.postCacheWarmer != nil {
+		s.postCacheWarmer.Warm(r.Context(), web.Post{
+			ID:     id,
+			ImgUrl: object.URL(),
+			Status: web.PostStatusPublished,
+			Author: params.Author,
+		})
+	}
+	readPrimaryAfterWrite(w)
 	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 
 }
@@ -113,11 +268,19 @@ func (s *Server) postsUpvoteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 
 	}
-	err = s.postDatabaseService.UpvotePost(r.Context(), uid)
+	err = s.postVoteService.UpvotePost(r.Context(), web.VotePostParams{
+		ID:    uid,
+		Voter: visitorID(w, r),
+	})
+	if errors.Is(err, web.ErrPostNotFound) {
+		httpError(w, http.StatusNotFound, "post not found", err)
+		return
+	}
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, "server error", err)
 		return
 	}
+	readPrimaryAfterWrite(w)
 	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 }
 
@@ -135,65 +298,110 @@ func (s *Server) postsDownvoteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 
 	}
-	err = s.postDatabaseService.DownvotePost(r.Context(), uid)
+	err = s.postVoteService.DownvotePost(r.Context(), web.VotePostParams{
+		ID:    uid,
+		Voter: visitorID(w, r),
+	})
+	if errors.Is(err, web.ErrPostNotFound) {
+		httpError(w, http.StatusNotFound, "post not found", err)
+		return
+	}
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, "server error", err)
 		return
 	}
+	readPrimaryAfterWrite(w)
 	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 }
 
+func (s *Server) postsReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed", fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	uid, err := uuid.Parse(r.PostFormValue("id"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "malformed id params", fmt.Errorf("malformed id params"))
+		return
+	}
+	err = s.reportService.Report(r.Context(), web.CreateReportParams{
+		PostID:     uid,
+		Reporter:   visitorID(w, r),
+		ReporterIP: s.clientIP(r),
+		Reason:     web.ReportReason(r.PostFormValue("reason")),
+		Details:    r.PostFormValue("details"),
+	})
+	if errors.Is(err, web.ErrReportRateLimited) {
+		httpError(w, http.StatusTooManyRequests, "too many reports, try again later", err)
+		return
+	}
+	if errors.Is(err, web.ErrInvalidReportReason) {
+		httpError(w, http.StatusBadRequest, "invalid report reason", err)
+		return
+	}
+	if errors.Is(err, web.ErrPostNotFound) {
+		httpError(w, http.StatusNotFound, "post not found", err)
+		return
+	}
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "server error", err)
+		return
+	}
+	readPrimaryAfterWrite(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *Server) postsScoreHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ids, ok := r.URL.Query()["id"]
+	if !ok || len(ids) < 1 {
+		httpError(w, http.StatusBadRequest, "id params is missing", fmt.Errorf("id param is missing"))
+		return
+	}
+	id := ids[0]
+
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "malformed id params", fmt.Errorf("malformed id params"))
+		return
+
+	}
+	points, err := s.postDatabaseService.ScoreHistory(r.Context(), uid)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "server error", err)
+		return
+	}
+	if points == nil {
+		points = []web.ScorePoint{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(points)
+	if err != nil {
+		log.Printf("[ERROR] failed to encode score history: %s", err)
+	}
+}
+
 func (s *Server) postsHandler(w http.ResponseWriter, r *http.Request) {
 	posts, err := s.postDatabaseService.ListPosts(r.Context())
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, "failed to list posts", err)
 		return
 	}
+	posts = s.postVoteService.MergePendingScores(r.Context(), posts)
 
-	postsAscii := make([]string, len(posts))
-	for i, post := range posts {
-		cachedAscii, err := s.postCacheService.GetPost(r.Context(), post.ID)
-		if err != nil && err != web.ErrPostCacheNotFound {
-			log.Println("[WARNING] failed to retreive ascii in cache")
-		}
-		if len(cachedAscii) == 0 {
-			obj, err := web.NewObjectPath(post.ImgUrl)
-			if err != nil {
-				httpError(w, http.StatusInternalServerError, "invalid object path", err)
-				return
-			}
-			fileReader, err := s.postStorageService.Get(r.Context(), obj)
-			if err != nil {
-				httpError(w, http.StatusInternalServerError, "failed to get object", err)
-				return
-			}
-			postsAscii[i], err = web.GenerateAscii(fileReader)
-			if err != nil {
-				httpError(w, http.StatusInternalServerError, "failed to generate post ascii", err)
-				return
-			}
-
-			ttlConfig := config.Env().Get("CACHE_TTL")
-			ttl, err := time.ParseDuration(ttlConfig)
-			if err != nil {
-				httpError(w, http.StatusInternalServerError, "invalid CACHE_TTL duration format", err)
-				return
-			}
-			_, err = s.postCacheService.CachePost(r.Context(), post.ID, postsAscii[i], ttl)
-			if err != nil {
-				log.Println("[WARNING] failed to cache ascii")
-			}
-			continue
-		}
-		postsAscii[i] = cachedAscii
+	opts, err := s.renderOptions(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error(), err)
+		return
 	}
-	postsAsciiHTML := make([]template.HTML, len(postsAscii))
-	for i, postAscii := range postsAscii {
-		postsAsciiHTML[i] = template.HTML(postAscii)
+	postsAsciiHTML := make([]template.HTML, len(posts))
+	for i, post := range posts {
+		postsAsciiHTML[i] = s.postAsciiHTML(r, post, opts)
 	}
 	err = templates.RenderPosts(w, templates.RenderPostsArgs{
-		Posts:          posts,
-		PostsAsciiHTML: postsAsciiHTML,
+		Posts:           posts,
+		PostsAsciiHTML:  postsAsciiHTML,
+		ReportReasons:   web.ReportReasons,
+		AnimationScript: template.JS(render.AnimationScript),
 	})
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, "failed to render posts", err)
@@ -202,7 +410,15 @@ func (s *Server) postsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
-	_, err := fmt.Fprint(w, "200 OK")
+	health := "200 OK"
+	if s.postCacheBreaker != nil {
+		state := s.postCacheBreaker.State()
+		health += fmt.Sprintf("\ncache circuit: %s", state)
+		if state != web.CircuitClosed {
+			health += " (degraded mode, serving in-memory cache)"
+		}
+	}
+	_, err := fmt.Fprint(w, health)
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, "failed to render posts", err)
 		return
@@ -211,16 +427,37 @@ func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) Run() {
-	http.HandleFunc("/", s.postsHandler)
-	http.HandleFunc("/upvote", s.postsUpvoteHandler)
-	http.HandleFunc("/downvote", s.postsDownvoteHandler)
-	http.HandleFunc("/upload", s.postsUploadHandler)
-	http.HandleFunc("/healthz", s.healthzHandler)
+	// not the default mux, where importing expvar publishes /debug/vars
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.postsHandler)
+	mux.HandleFunc("/upvote", s.rejectBanned(s.postsUpvoteHandler))
+	mux.HandleFunc("/downvote", s.rejectBanned(s.postsDownvoteHandler))
+	mux.HandleFunc("/upload", s.rejectBanned(s.postsUploadHandler))
+	mux.HandleFunc("/report", s.rejectBanned(s.postsReportHandler))
+	mux.HandleFunc(postRenderPath, s.postRenderHandler)
+	mux.HandleFunc("/api/posts/score-history", s.postsScoreHistoryHandler)
+
+	mux.HandleFunc("/admin", s.requireRole(web.RoleModerator, s.adminPostsHandler))
+	mux.HandleFunc("/admin/posts/bulk", s.requireRole(web.RoleModerator, s.adminPostsBulkHandler))
+	mux.HandleFunc(adminPostRenderPath, s.requireRole(web.RoleModerator, s.adminPostRenderHandler))
+	mux.HandleFunc("/admin/reports", s.requireRole(web.RoleModerator, s.adminReportsHandler))
+	mux.HandleFunc("/admin/reports/dismiss", s.requireRole(web.RoleModerator, s.adminReportsDismissHandler))
+	mux.HandleFunc("/admin/reports/remove", s.requireRole(web.RoleModerator, s.adminReportsRemoveHandler))
+	mux.HandleFunc("/admin/render-failures", s.requireRole(web.RoleModerator, s.adminRenderFailuresHandler))
+	mux.HandleFunc("/admin/render-failures/retry", s.requireRole(web.RoleModerator, s.adminRenderFailuresRetryHandler))
+	mux.HandleFunc("/admin/bans", s.requireRole(web.RoleAdmin, s.adminBansHandler))
+	mux.HandleFunc("/admin/bans/delete", s.requireRole(web.RoleAdmin, s.adminBansDeleteHandler))
+	mux.HandleFunc("/admin/users", s.requireRole(web.RoleAdmin, s.adminUsersHandler))
+	mux.HandleFunc("/admin/users/delete", s.requireRole(web.RoleAdmin, s.adminUsersDeleteHandler))
+	mux.HandleFunc("/admin/cache/purge", s.requireRole(web.RoleAdmin, s.adminCachePurgeHandler))
+	mux.HandleFunc("/admin/votes/replay", s.requireRole(web.RoleAdmin, s.adminVotesReplayHandler))
+	mux.HandleFunc("/healthz", s.healthzHandler)
+	mux.HandleFunc("/debug/vars", s.requireRole(web.RoleAdmin, expvar.Handler().ServeHTTP))
 
-	http.HandleFunc("/favicon.ico", s.voidHandler)
+	mux.HandleFunc("/favicon.ico", s.voidHandler)
 
 	log.Printf("HTTP Server running on %s...\n", s.listenAddr)
-	if err := http.ListenAndServe(s.listenAddr, nil); err != nil {
+	if err := http.ListenAndServe(s.listenAddr, readYourWrites(mux)); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,80 @@
+package templates
+
+import (
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/skale-5/skalogram/web"
+)
+
+//go:embed admin.html bans.html users.html render_failures.html post_scripts.html
+var adminFS embed.FS
+
+type RenderAdminPostsArgs struct {
+	User           web.User
+	Status         web.PostStatus
+	Statuses       []web.PostStatus
+	Posts          []web.Post
+	PostsAsciiHTML []template.HTML
+	// CacheNamespaces is only listed to admins
+	CacheNamespaces []web.CacheNamespace
+	CacheNamespace  string
+	// DeadVotes is only counted for admins
+	DeadVotes int64
+	// AnimationScript plays the animated posts.
+	AnimationScript template.JS
+}
+
+func RenderAdminPosts(w http.ResponseWriter, args RenderAdminPostsArgs) error {
+	tpl, err := template.ParseFS(adminFS, "admin.html", "post_scripts.html")
+	if err != nil {
+		log.Fatalf("failed to load admin.html template: %s", err)
+	}
+	return tpl.Execute(w, args)
+}
+
+type RenderAdminBansArgs struct {
+	User web.User
+	Bans []web.Ban
+	// IP and Visitor prefill the ban form, the votes of Visitor being
+	// purged on request.
+	IP      string
+	Visitor string
+}
+
+func RenderAdminBans(w http.ResponseWriter, args RenderAdminBansArgs) error {
+	tpl, err := template.ParseFS(adminFS, "bans.html")
+	if err != nil {
+		log.Fatalf("failed to load bans.html template: %s", err)
+	}
+	return tpl.Execute(w, args)
+}
+
+type RenderAdminUsersArgs struct {
+	User  web.User
+	Users []web.User
+	Roles []web.Role
+}
+
+func RenderAdminUsers(w http.ResponseWriter, args RenderAdminUsersArgs) error {
+	tpl, err := template.ParseFS(adminFS, "users.html")
+	if err != nil {
+		log.Fatalf("failed to load users.html template: %s", err)
+	}
+	return tpl.Execute(w, args)
+}
+
+type RenderAdminRenderFailuresArgs struct {
+	User     web.User
+	Failures []web.RenderFailure
+}
+
+func RenderAdminRenderFailures(w http.ResponseWriter, args RenderAdminRenderFailuresArgs) error {
+	tpl, err := template.ParseFS(adminFS, "render_failures.html")
+	if err != nil {
+		log.Fatalf("failed to load render_failures.html template: %s", err)
+	}
+	return tpl.Execute(w, args)
+}
@@ -9,16 +9,19 @@ import (
 	"github.com/skale-5/skalogram/web"
 )
 
-//go:embed posts.html
+//go:embed posts.html post_scripts.html
 var postsFS embed.FS
 
 type RenderPostsArgs struct {
 	Posts          []web.Post
 	PostsAsciiHTML []template.HTML
+	ReportReasons  []web.ReportReason
+	// AnimationScript plays the animated posts.
+	AnimationScript template.JS
 }
 
 func RenderPosts(w http.ResponseWriter, args RenderPostsArgs) error {
-	tpl, err := template.ParseFS(postsFS, "posts.html")
+	tpl, err := template.ParseFS(postsFS, "posts.html", "post_scripts.html")
 	if err != nil {
 		log.Fatalf("failed to load posts.html template: %s", err)
 	}
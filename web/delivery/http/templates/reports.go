@@ -0,0 +1,29 @@
+package templates
+
+import (
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/skale-5/skalogram/web"
+)
+
+//go:embed reports.html post_scripts.html
+var reportsFS embed.FS
+
+type RenderReportsArgs struct {
+	User           web.User
+	Queue          []web.ReportedPost
+	PostsAsciiHTML []template.HTML
+	// AnimationScript plays the animated posts.
+	AnimationScript template.JS
+}
+
+func RenderReports(w http.ResponseWriter, args RenderReportsArgs) error {
+	tpl, err := template.ParseFS(reportsFS, "reports.html", "post_scripts.html")
+	if err != nil {
+		log.Fatalf("failed to load reports.html template: %s", err)
+	}
+	return tpl.Execute(w, args)
+}
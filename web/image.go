@@ -0,0 +1,60 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+)
+
+// ImageFormats are the formats accepted for posts, named as registered with
+// the image package by the render package.
+var ImageFormats = []string{"png", "jpeg", "gif", "webp", "bmp", "tiff"}
+
+// canonicalImageFormats are stored as uploaded when transcoding, GIFs
+// keeping their animation.
+var canonicalImageFormats = map[string]bool{
+	"png":  true,
+	"jpeg": true,
+	"gif":  true,
+}
+
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// SniffImage identifies the format of an image from its content rather than
+// its declared content type, and rewinds it. Its config tells its size
+// before it is decoded.
+func SniffImage(r io.ReadSeeker) (image.Config, string, error) {
+	config, format, err := image.DecodeConfig(r)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return image.Config{}, "", fmt.Errorf("cannot rewind image: %w", err)
+	}
+	if err != nil {
+		return image.Config{}, "", fmt.Errorf("%w: %s", ErrUnsupportedImage, err)
+	}
+	for _, f := range ImageFormats {
+		if f == format {
+			return config, format, nil
+		}
+	}
+	return image.Config{}, "", fmt.Errorf("%w: %s", ErrUnsupportedImage, format)
+}
+
+// TranscodeImage re-encodes the images which are not PNG, JPEG or GIF as
+// PNG, the others are returned as is.
+func TranscodeImage(r io.Reader, format string) (io.Reader, error) {
+	if canonicalImageFormats[format] {
+		return r, nil
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %s image: %w", format, err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("cannot encode %s image as png: %w", format, err)
+	}
+	return &buf, nil
+}
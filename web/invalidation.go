@@ -0,0 +1,120 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PostInvalidation tells the instances to drop their local copy of a post.
+// Origin identifies the instance which published it.
+type PostInvalidation struct {
+	ID     uuid.UUID
+	Origin string
+}
+
+type PostInvalidationAdapter interface {
+	PublishInvalidation(ctx context.Context, inv PostInvalidation) error
+	// SubscribeInvalidations calls handle for every invalidation published
+	// until ctx is done or the subscription fails.
+	SubscribeInvalidations(ctx context.Context, handle func(PostInvalidation)) error
+}
+
+const (
+	invalidationRetryInterval  = time.Second
+	invalidationPublishTimeout = time.Second
+)
+
+// PostInvalidationService evicts the posts changed by other instances from
+// the caches local to this instance. Invalidations published while an
+// instance is disconnected are lost, local entries TTL bounds the staleness
+// in that case.
+type PostInvalidationService struct {
+	adapter PostInvalidationAdapter
+	origin  string
+	local   []PostCacheAdapter
+}
+
+func NewPostInvalidationService(a PostInvalidationAdapter, local ...PostCacheAdapter) *PostInvalidationService {
+	return &PostInvalidationService{
+		adapter: a,
+		origin:  uuid.New().String(),
+		local:   local,
+	}
+}
+
+// Publish sends the invalidation of a post in the background, a lost
+// invalidation only delays the eviction until the local entries expire.
+func (pis *PostInvalidationService) Publish(id uuid.UUID) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), invalidationPublishTimeout)
+		defer cancel()
+		err := pis.adapter.PublishInvalidation(ctx, PostInvalidation{
+			ID:     id,
+			Origin: pis.origin,
+		})
+		if err != nil && !errors.Is(err, ErrCircuitOpen) {
+			log.Printf("[WARNING] failed to publish post %s invalidation: %s\n", id, err)
+		}
+	}()
+}
+
+// Run subscribes to the invalidations until ctx is done.
+func (pis *PostInvalidationService) Run(ctx context.Context) {
+	for {
+		err := pis.adapter.SubscribeInvalidations(ctx, pis.evict)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[WARNING] post invalidation subscription failed: %s\n", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(invalidationRetryInterval):
+		}
+	}
+}
+
+func (pis *PostInvalidationService) evict(inv PostInvalidation) {
+	if inv.Origin == pis.origin {
+		return
+	}
+	for _, cache := range pis.local {
+		cache.DeletePost(context.Background(), inv.ID)
+	}
+}
+
+// InvalidatingPostCacheAdapter publishes an invalidation every time a post
+// is deleted or an entry is replaced, the other instances cannot hold a
+// copy of an entry cached for the first time.
+type InvalidatingPostCacheAdapter struct {
+	PostCacheAdapter
+	invalidations *PostInvalidationService
+}
+
+func NewInvalidatingPostCacheAdapter(a PostCacheAdapter, pis *PostInvalidationService) *InvalidatingPostCacheAdapter {
+	return &InvalidatingPostCacheAdapter{
+		PostCacheAdapter: a,
+		invalidations:    pis,
+	}
+}
+
+func (c *InvalidatingPostCacheAdapter) CachePost(ctx context.Context, key PostCacheKey, entry []byte, ttl time.Duration) (bool, error) {
+	replaced, err := c.PostCacheAdapter.CachePost(ctx, key, entry, ttl)
+	if err != nil {
+		return false, err
+	}
+	if replaced {
+		c.invalidations.Publish(key.ID)
+	}
+	return replaced, nil
+}
+
+func (c *InvalidatingPostCacheAdapter) DeletePost(ctx context.Context, id uuid.UUID) error {
+	err := c.PostCacheAdapter.DeletePost(ctx, id)
+	c.invalidations.Publish(id)
+	return err
+}
@@ -0,0 +1,53 @@
+package web_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/web"
+	"github.com/skale-5/skalogram/web/pkg/memory"
+)
+
+type publishedInvalidations chan web.PostInvalidation
+
+func (p publishedInvalidations) PublishInvalidation(ctx context.Context, inv web.PostInvalidation) error {
+	p <- inv
+	return nil
+}
+
+func (p publishedInvalidations) SubscribeInvalidations(ctx context.Context, handle func(web.PostInvalidation)) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestInvalidatingPostCacheAdapter(t *testing.T) {
+	ctx := context.Background()
+	published := make(publishedInvalidations, 10)
+	cache := web.NewInvalidatingPostCacheAdapter(memory.NewClient(10, 0), web.NewPostInvalidationService(published))
+	key := web.PostCacheKey{ID: uuid.New(), Kind: web.PostCacheEntryHTML}
+
+	if replaced, err := cache.CachePost(ctx, key, []byte("first"), time.Minute); replaced || err != nil {
+		t.Fatalf("got %t, %v caching a new entry", replaced, err)
+	}
+	if replaced, err := cache.CachePost(ctx, key, []byte("second"), time.Minute); !replaced || err != nil {
+		t.Fatalf("got %t, %v replacing an entry", replaced, err)
+	}
+	cache.DeletePost(ctx, key.ID)
+	for i := 0; i < 2; i++ {
+		select {
+		case inv := <-published:
+			if inv.ID != key.ID {
+				t.Errorf("got invalidation of post %s, want %s", inv.ID, key.ID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got %d invalidations, want 2, of the replaced entry and the deleted post", i)
+		}
+	}
+	select {
+	case <-published:
+		t.Error("published an invalidation of the new entry")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,92 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+const outboxBatchSize = 100
+
+// PostOutboxWorker reconciles pending posts with the object storage: posts
+// whose object landed are published, posts whose upload never completed
+// are rolled back once PendingTimeout has elapsed.
+type PostOutboxWorker struct {
+	postDatabaseService *PostDatabaseService
+	postStorageService  *PostStorageService
+	pollInterval        time.Duration
+	pendingTimeout      time.Duration
+}
+
+type NewPostOutboxWorkerArgs struct {
+	PostDatabaseService *PostDatabaseService
+	PostStorageService  *PostStorageService
+	PollInterval        time.Duration
+	PendingTimeout      time.Duration
+}
+
+func NewPostOutboxWorker(args NewPostOutboxWorkerArgs) *PostOutboxWorker {
+	return &PostOutboxWorker{
+		postDatabaseService: args.PostDatabaseService,
+		postStorageService:  args.PostStorageService,
+		pollInterval:        args.PollInterval,
+		pendingTimeout:      args.PendingTimeout,
+	}
+}
+
+func (w *PostOutboxWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.process(ctx); err != nil {
+			log.Printf("[WARNING] outbox worker: %s\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *PostOutboxWorker) process(ctx context.Context) error {
+	entries, err := w.postDatabaseService.ListOutbox(ctx, outboxBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := w.reconcile(ctx, entry); err != nil {
+			log.Printf("[WARNING] outbox worker: failed to reconcile post %s: %s\n", entry.PostID, err)
+		}
+	}
+	return nil
+}
+
+func (w *PostOutboxWorker) reconcile(ctx context.Context, entry OutboxEntry) error {
+	object, err := NewObjectPath(entry.ImgUrl)
+	if err != nil {
+		return err
+	}
+	exists, err := w.postStorageService.Exists(ctx, object)
+	if err != nil {
+		return err
+	}
+	if exists {
+		err := w.postDatabaseService.PublishPost(ctx, entry.PostID)
+		if errors.Is(err, ErrPostNotFound) {
+			// published by its upload meanwhile
+			return nil
+		}
+		return err
+	}
+	if time.Since(entry.CreatedAt) < w.pendingTimeout {
+		// upload may still be in flight
+		return nil
+	}
+	if err := w.postStorageService.Delete(ctx, object); err != nil {
+		return err
+	}
+	return w.postDatabaseService.DiscardPost(ctx, entry.PostID)
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// outboxDatabase publishes the posts still pending only.
+type outboxDatabase struct {
+	PostDatabaseAdapter
+	pending   map[uuid.UUID]bool
+	discarded []uuid.UUID
+}
+
+func (db *outboxDatabase) PublishPost(ctx context.Context, id uuid.UUID) error {
+	if !db.pending[id] {
+		return ErrPostNotFound
+	}
+	delete(db.pending, id)
+	return nil
+}
+
+func (db *outboxDatabase) DiscardPost(ctx context.Context, id uuid.UUID) error {
+	delete(db.pending, id)
+	db.discarded = append(db.discarded, id)
+	return nil
+}
+
+type outboxStorage struct {
+	PostStorageAdapter
+	objects map[string]bool
+}
+
+func (s *outboxStorage) Exists(ctx context.Context, object *ObjectPath) (bool, error) {
+	return s.objects[object.URL()], nil
+}
+
+func (s *outboxStorage) Delete(ctx context.Context, object *ObjectPath) error {
+	delete(s.objects, object.URL())
+	return nil
+}
+
+func TestPostOutboxWorkerReconcile(t *testing.T) {
+	ctx := context.Background()
+	landed, published, slow, stale := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	url := func(id uuid.UUID) string { return "s3://bucket/" + id.String() }
+	db := &outboxDatabase{pending: map[uuid.UUID]bool{landed: true, slow: true, stale: true}}
+	storage := &outboxStorage{objects: map[string]bool{url(landed): true, url(published): true}}
+	w := NewPostOutboxWorker(NewPostOutboxWorkerArgs{
+		PostDatabaseService: NewPostDatabaseService(db),
+		PostStorageService:  NewPostStorageService(storage),
+		PendingTimeout:      time.Minute,
+	})
+
+	entries := []OutboxEntry{
+		{PostID: landed, ImgUrl: url(landed), CreatedAt: time.Now()},
+		{PostID: published, ImgUrl: url(published), CreatedAt: time.Now()},
+		{PostID: slow, ImgUrl: url(slow), CreatedAt: time.Now()},
+		{PostID: stale, ImgUrl: url(stale), CreatedAt: time.Now().Add(-time.Hour)},
+	}
+	for _, entry := range entries {
+		if err := w.reconcile(ctx, entry); err != nil {
+			t.Errorf("post %s: %s", entry.PostID, err)
+		}
+	}
+	if db.pending[landed] || !db.pending[slow] {
+		t.Errorf("got pending posts %v, want the slow upload only", db.pending)
+	}
+	if len(db.discarded) != 1 || db.discarded[0] != stale {
+		t.Errorf("got discarded posts %v, want the stale upload only", db.discarded)
+	}
+}
@@ -2,6 +2,8 @@ package gcs
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 
@@ -27,21 +29,46 @@ func NewClient(ctx context.Context) *Client {
 func (c *Client) Write(ctx context.Context, object *web.ObjectPath, content io.Reader) error {
 	obj := c.storage.Bucket(object.Bucket).Object(object.Path)
 	w := obj.NewWriter(ctx)
-	defer w.Close()
 
 	_, err := io.Copy(w, content)
 	if err != nil && err != io.EOF {
+		w.Close()
 		return err
 	}
 
-	return nil
+	// the object is only committed once the writer is closed
+	return w.Close()
 }
 
 func (c *Client) Get(ctx context.Context, object *web.ObjectPath) (io.ReadCloser, error) {
 	obj := c.storage.Bucket(object.Bucket).Object(object.Path)
 	r, err := obj.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("%w: %s", web.ErrObjectNotFound, object.URL())
+	}
 	if err != nil {
 		return nil, err
 	}
 	return r, nil
 }
+
+func (c *Client) Exists(ctx context.Context, object *web.ObjectPath) (bool, error) {
+	obj := c.storage.Bucket(object.Bucket).Object(object.Path)
+	_, err := obj.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Client) Delete(ctx context.Context, object *web.ObjectPath) error {
+	obj := c.storage.Bucket(object.Bucket).Object(object.Path)
+	err := obj.Delete(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/web"
+)
+
+type entry struct {
+	key       web.PostCacheKey
+	content   []byte
+	expiresAt time.Time
+}
+
+// Client is an in-process LRU cache bounded by a number of entries and a
+// maximum TTL.
+type Client struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxTTL     time.Duration
+	ll         *list.List
+	entries    map[web.PostCacheKey]*list.Element
+}
+
+func NewClient(maxEntries int, maxTTL time.Duration) *Client {
+	return &Client{
+		maxEntries: maxEntries,
+		maxTTL:     maxTTL,
+		ll:         list.New(),
+		entries:    make(map[web.PostCacheKey]*list.Element),
+	}
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *Client) CachePost(ctx context.Context, key web.PostCacheKey, content []byte, ttl time.Duration) (bool, error) {
+	if c.maxTTL > 0 && (ttl <= 0 || ttl > c.maxTTL) {
+		ttl = c.maxTTL
+	}
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.ll.MoveToFront(el)
+		e := el.Value.(*entry)
+		replaced := e.expiresAt.IsZero() || time.Now().Before(e.expiresAt)
+		e.content = content
+		e.expiresAt = expiresAt
+		return replaced, nil
+	}
+	c.entries[key] = c.ll.PushFront(&entry{
+		key:       key,
+		content:   content,
+		expiresAt: expiresAt,
+	})
+	for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+	return false, nil
+}
+
+func (c *Client) GetPost(ctx context.Context, key web.PostCacheKey) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, web.ErrPostCacheNotFound
+	}
+	e := el.Value.(*entry)
+	if !e.expiresAt.IsZero() && time.Now().After(e.expiresAt) {
+		c.removeElement(el)
+		return nil, web.ErrPostCacheNotFound
+	}
+	c.ll.MoveToFront(el)
+	return e.content, nil
+}
+
+func (c *Client) DeletePost(ctx context.Context, id uuid.UUID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.entries {
+		if key.ID == id {
+			c.removeElement(el)
+		}
+	}
+	return nil
+}
+
+func (c *Client) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *Client) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/skale-5/skalogram/web"
+)
+
+// dequeueTimeout bounds the wait of DequeueRender, like the blocking pop of
+// the Redis queue.
+const dequeueTimeout = time.Second
+
+// RenderQueue is an in-process render queue, for development and single
+// instance deployments.
+type RenderQueue struct {
+	jobs chan web.RenderJob
+
+	mu      sync.Mutex
+	pending map[string]time.Time
+}
+
+func NewRenderQueue(size int) *RenderQueue {
+	return &RenderQueue{
+		jobs:    make(chan web.RenderJob, size),
+		pending: make(map[string]time.Time),
+	}
+}
+
+func (q *RenderQueue) EnqueueRender(ctx context.Context, job web.RenderJob, pendingTTL time.Duration, maxLength int) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := job.Key()
+	if expiresAt, ok := q.pending[key]; ok && time.Now().Before(expiresAt) {
+		return false, nil
+	}
+	if len(q.jobs) >= maxLength {
+		return false, web.ErrRenderQueueFull
+	}
+	select {
+	case q.jobs <- job:
+		q.pending[key] = time.Now().Add(pendingTTL)
+		return true, nil
+	default:
+		return false, web.ErrRenderQueueFull
+	}
+}
+
+func (q *RenderQueue) DequeueRender(ctx context.Context) (web.RenderJob, error) {
+	select {
+	case <-ctx.Done():
+		return web.RenderJob{}, ctx.Err()
+	case job := <-q.jobs:
+		return job, nil
+	case <-time.After(dequeueTimeout):
+		return web.RenderJob{}, web.ErrRenderQueueEmpty
+	}
+}
+
+func (q *RenderQueue) DoneRender(ctx context.Context, job web.RenderJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.pending, job.Key())
+	return nil
+}
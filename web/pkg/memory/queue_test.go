@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/render"
+	"github.com/skale-5/skalogram/web"
+)
+
+func TestRenderQueue(t *testing.T) {
+	ctx := context.Background()
+	q := NewRenderQueue(10)
+	job := web.RenderJob{PostID: uuid.New(), Options: render.DefaultOptions()}
+
+	if queued, err := q.EnqueueRender(ctx, job, time.Minute, 2); !queued || err != nil {
+		t.Fatalf("got %t, %v, want the job queued", queued, err)
+	}
+	if queued, err := q.EnqueueRender(ctx, job, time.Minute, 2); queued || err != nil {
+		t.Fatalf("got %t, %v, want the pending job deduplicated", queued, err)
+	}
+	other := web.RenderJob{PostID: uuid.New(), Options: render.DefaultOptions()}
+	q.EnqueueRender(ctx, other, time.Minute, 2)
+	full := web.RenderJob{PostID: uuid.New(), Options: render.DefaultOptions()}
+	if _, err := q.EnqueueRender(ctx, full, time.Minute, 2); err != web.ErrRenderQueueFull {
+		t.Fatalf("got %v, want ErrRenderQueueFull", err)
+	}
+
+	got, err := q.DequeueRender(ctx)
+	if err != nil || got.Key() != job.Key() {
+		t.Fatalf("got %+v, %v, want the first job", got, err)
+	}
+	if err := q.DoneRender(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if queued, err := q.EnqueueRender(ctx, job, time.Minute, 2); !queued || err != nil {
+		t.Errorf("got %t, %v, want the done job queued again", queued, err)
+	}
+}
+
+func TestRenderQueueDequeueCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewRenderQueue(1).DequeueRender(ctx); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestRenderQueueDequeueEmpty(t *testing.T) {
+	if _, err := NewRenderQueue(1).DequeueRender(context.Background()); err != web.ErrRenderQueueEmpty {
+		t.Errorf("got %v, want ErrRenderQueueEmpty", err)
+	}
+}
@@ -3,6 +3,7 @@ package post
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/skale-5/skalogram/web"
@@ -15,6 +16,96 @@ CREATE TABLE IF NOT EXISTS posts (
 	img_url TEXT NOT NULL,
 	created_at TIMESTAMP NOT NULL DEFAULT now()
 );
+
+CREATE TABLE IF NOT EXISTS votes (
+	id BIGSERIAL PRIMARY KEY,
+	post_id UUID NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+	voter TEXT NOT NULL,
+	value SMALLINT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS votes_post_id_created_at_idx ON votes (post_id, created_at);
+CREATE INDEX IF NOT EXISTS votes_voter_idx ON votes (voter);
+
+CREATE TABLE IF NOT EXISTS migrations (
+	name TEXT PRIMARY KEY,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- the scores of the posts created before the vote event log are seeded
+-- once as a baseline vote event, so that recomputing them keeps them
+WITH applied AS (
+	INSERT INTO migrations (name) VALUES ('vote_baselines')
+	ON CONFLICT DO NOTHING
+	RETURNING name
+)
+INSERT INTO votes (post_id, voter, value, created_at)
+SELECT posts.id, 'baseline', posts.score - COALESCE(SUM(votes.value), 0), posts.created_at
+FROM posts LEFT JOIN votes ON votes.post_id = posts.id
+WHERE EXISTS (SELECT 1 FROM applied)
+GROUP BY posts.id
+HAVING posts.score <> COALESCE(SUM(votes.value), 0);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published';
+
+CREATE TABLE IF NOT EXISTS post_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	post_id UUID NOT NULL UNIQUE REFERENCES posts (id) ON DELETE CASCADE,
+	img_url TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS status_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS moderator_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS status_updated_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS reports (
+	id BIGSERIAL PRIMARY KEY,
+	post_id UUID NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+	reporter TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	details TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	resolved_at TIMESTAMPTZ,
+	resolution TEXT,
+	moderator_id TEXT
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS reports_open_post_id_reporter_idx ON reports (post_id, reporter) WHERE resolved_at IS NULL;
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS reporter_ip TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS reports_reporter_ip_created_at_idx ON reports (reporter_ip, created_at);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS author TEXT NOT NULL DEFAULT '';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS author_ip TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS users (
+	name TEXT PRIMARY KEY,
+	role TEXT NOT NULL,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS ip_bans (
+	ip TEXT PRIMARY KEY,
+	reason TEXT NOT NULL DEFAULT '',
+	moderator_id TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS vote_batches (
+	id TEXT PRIMARY KEY,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS render_failures (
+	post_id UUID PRIMARY KEY REFERENCES posts (id) ON DELETE CASCADE,
+	category TEXT NOT NULL,
+	message TEXT NOT NULL,
+	failures INTEGER NOT NULL DEFAULT 1,
+	first_failed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_failed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
 `
 
 func (q *Queries) CreateTable(ctx context.Context) error {
@@ -24,50 +115,185 @@ func (q *Queries) CreateTable(ctx context.Context) error {
 
 const createPost = `-- name: CreatePost :execresult
 INSERT INTO posts (
-  id, img_url
+  id, img_url, author, author_ip
 ) VALUES (
-  $1, $2
+  $1, $2, $3, $4
 )
 `
 
 func (q *Queries) CreatePost(ctx context.Context, arg web.CreatePostParams) (sql.Result, error) {
-	return q.db.ExecContext(ctx, createPost, arg.ID, arg.ImgUrl)
+	return q.db.ExecContext(ctx, createPost, arg.ID, arg.ImgUrl, arg.Author, arg.AuthorIP)
+}
+
+const createPendingPost = `-- name: createPendingPost :exec
+INSERT INTO posts (
+  id, img_url, author, author_ip, status
+) VALUES (
+  $1, $2, $3, $4, 'pending'
+)
+`
+
+func (q *Queries) createPendingPost(ctx context.Context, arg web.CreatePostParams) error {
+	_, err := q.db.ExecContext(ctx, createPendingPost, arg.ID, arg.ImgUrl, arg.Author, arg.AuthorIP)
+	return err
+}
+
+const createOutboxEntry = `-- name: createOutboxEntry :exec
+INSERT INTO post_outbox (
+  post_id, img_url
+) VALUES (
+  $1, $2
+)
+`
+
+func (q *Queries) createOutboxEntry(ctx context.Context, arg web.CreatePostParams) error {
+	_, err := q.db.ExecContext(ctx, createOutboxEntry, arg.ID, arg.ImgUrl)
+	return err
+}
+
+const publishPost = `-- name: publishPost :exec
+UPDATE posts
+SET status = 'published'
+WHERE id = $1 AND status = 'pending'
+`
+
+func (q *Queries) publishPost(ctx context.Context, id uuid.UUID) error {
+	res, err := q.db.ExecContext(ctx, publishPost, id)
+	return expectRows(res, err)
 }
 
-const deletePost = `-- name: DeletePost :exec
+const deleteOutboxEntry = `-- name: deleteOutboxEntry :exec
+DELETE FROM post_outbox
+WHERE post_id = $1
+`
+
+func (q *Queries) deleteOutboxEntry(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteOutboxEntry, id)
+	return err
+}
+
+const discardPendingPost = `-- name: discardPendingPost :exec
 DELETE FROM posts
-WHERE id = $1
+WHERE id = $1 AND status = 'pending'
 `
 
-func (q *Queries) DeletePost(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deletePost, id)
+func (q *Queries) discardPendingPost(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, discardPendingPost, id)
 	return err
 }
 
+const listOutbox = `-- name: ListOutbox :many
+SELECT id, post_id, img_url, created_at FROM post_outbox
+ORDER BY created_at ASC
+LIMIT $1
+`
+
+func (q *Queries) ListOutbox(ctx context.Context, limit int) ([]web.OutboxEntry, error) {
+	rows, err := q.db.QueryContext(ctx, listOutbox, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []web.OutboxEntry
+	for rows.Next() {
+		var i web.OutboxEntry
+		if err := rows.Scan(
+			&i.ID,
+			&i.PostID,
+			&i.ImgUrl,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deletePost = `-- name: DeletePost :execresult
+UPDATE posts
+SET status = 'removed', status_reason = $2, moderator_id = $3, status_updated_at = now()
+WHERE id = $1 AND status IN ('published', 'hidden', 'pending_review')
+`
+
+func (q *Queries) DeletePost(ctx context.Context, arg web.ModeratePostParams) error {
+	res, err := q.db.ExecContext(ctx, deletePost, arg.ID, arg.Reason, arg.ModeratorID)
+	return expectRows(res, err)
+}
+
+const restorePost = `-- name: RestorePost :execresult
+UPDATE posts
+SET status = 'published', status_reason = $2, moderator_id = $3, status_updated_at = now()
+WHERE id = $1 AND status IN ('hidden', 'pending_review', 'removed')
+`
+
+func (q *Queries) RestorePost(ctx context.Context, arg web.ModeratePostParams) error {
+	res, err := q.db.ExecContext(ctx, restorePost, arg.ID, arg.Reason, arg.ModeratorID)
+	return expectRows(res, err)
+}
+
+const setPostStatus = `-- name: SetPostStatus :execresult
+UPDATE posts
+SET status = $2, status_reason = $3, moderator_id = $4, status_updated_at = now()
+WHERE id = $1 AND status <> 'pending'
+`
+
+func (q *Queries) SetPostStatus(ctx context.Context, arg web.SetPostStatusParams) error {
+	res, err := q.db.ExecContext(ctx, setPostStatus, arg.ID, arg.Status, arg.Reason, arg.ModeratorID)
+	return expectRows(res, err)
+}
+
+// expectRows turns an update matching no row into web.ErrPostNotFound.
+func expectRows(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return web.ErrPostNotFound
+	}
+	return nil
+}
+
 const getPost = `-- name: GetPost :one
-SELECT id, score, img_url, created_at FROM posts
+SELECT id, score, img_url, status, status_reason, moderator_id, author, author_ip, created_at FROM posts
 WHERE id = $1 LIMIT 1
 `
 
 func (q *Queries) GetPost(ctx context.Context, id uuid.UUID) (web.Post, error) {
-	row := q.db.QueryRowContext(ctx, getPost, id)
+	row := q.read.QueryRowContext(ctx, getPost, id)
 	var i web.Post
 	err := row.Scan(
 		&i.ID,
 		&i.Score,
 		&i.ImgUrl,
+		&i.Status,
+		&i.StatusReason,
+		&i.ModeratorID,
+		&i.Author,
+		&i.AuthorIP,
 		&i.CreatedAt,
 	)
 	return i, err
 }
 
 const listPosts = `-- name: ListPosts :many
-SELECT id, score, img_url, created_at FROM posts
+SELECT id, score, img_url, status, status_reason, moderator_id, author, author_ip, created_at FROM posts
+WHERE status = 'published'
 ORDER BY created_at ASC
 `
 
 func (q *Queries) ListPosts(ctx context.Context) ([]web.Post, error) {
-	rows, err := q.db.QueryContext(ctx, listPosts)
+	rows, err := q.read.QueryContext(ctx, listPosts)
 	if err != nil {
 		return nil, err
 	}
@@ -79,6 +305,11 @@ func (q *Queries) ListPosts(ctx context.Context) ([]web.Post, error) {
 			&i.ID,
 			&i.Score,
 			&i.ImgUrl,
+			&i.Status,
+			&i.StatusReason,
+			&i.ModeratorID,
+			&i.Author,
+			&i.AuthorIP,
 			&i.CreatedAt,
 		); err != nil {
 			return nil, err
@@ -94,23 +325,177 @@ func (q *Queries) ListPosts(ctx context.Context) ([]web.Post, error) {
 	return items, nil
 }
 
-const upvotePost = `-- name: UpvotePost :exec
+const listPostsByStatus = `-- name: ListPostsByStatus :many
+SELECT id, score, img_url, status, status_reason, moderator_id, author, author_ip, created_at FROM posts
+WHERE status = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListPostsByStatus(ctx context.Context, status web.PostStatus) ([]web.Post, error) {
+	rows, err := q.read.QueryContext(ctx, listPostsByStatus, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []web.Post
+	for rows.Next() {
+		var i web.Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.Score,
+			&i.ImgUrl,
+			&i.Status,
+			&i.StatusReason,
+			&i.ModeratorID,
+			&i.Author,
+			&i.AuthorIP,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const upvotePost = `-- name: UpvotePost :execresult
+WITH post AS (
+	UPDATE posts
+	SET score = score + 1
+	WHERE id = $1 AND status = 'published'
+	RETURNING id
+)
+INSERT INTO votes (post_id, voter, value)
+SELECT id, $2, 1 FROM post
+`
+
+func (q *Queries) UpvotePost(ctx context.Context, arg web.VotePostParams) error {
+	res, err := q.db.ExecContext(ctx, upvotePost, arg.ID, arg.Voter)
+	return expectRows(res, err)
+}
+
+const downvotePost = `-- name: DownvotePost :execresult
+WITH post AS (
+	UPDATE posts
+	SET score = score - 1
+	WHERE id = $1 AND status = 'published'
+	RETURNING id
+)
+INSERT INTO votes (post_id, voter, value)
+SELECT id, $2, -1 FROM post
+`
+
+func (q *Queries) DownvotePost(ctx context.Context, arg web.VotePostParams) error {
+	res, err := q.db.ExecContext(ctx, downvotePost, arg.ID, arg.Voter)
+	return expectRows(res, err)
+}
+
+const listScoreHistory = `-- name: ListScoreHistory :many
+SELECT created_at, SUM(value) OVER (ORDER BY created_at, id) AS score FROM votes
+WHERE post_id = $1
+ORDER BY created_at, id
+`
+
+func (q *Queries) ListScoreHistory(ctx context.Context, id uuid.UUID) ([]web.ScorePoint, error) {
+	rows, err := q.read.QueryContext(ctx, listScoreHistory, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []web.ScorePoint
+	for rows.Next() {
+		var i web.ScorePoint
+		if err := rows.Scan(
+			&i.At,
+			&i.Score,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const recomputeScores = `-- name: RecomputeScores :exec
 UPDATE posts
-SET score = score + 1
-WHERE id = $1
+SET score = COALESCE((SELECT SUM(value) FROM votes WHERE votes.post_id = posts.id), 0)
 `
 
-func (q *Queries) UpvotePost(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, upvotePost, id)
+func (q *Queries) RecomputeScores(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, recomputeScores)
 	return err
 }
 
-const downvotePost = `-- name: DownvotePost :exec
-UPDATE posts SET score = score - 1
+const deleteVoterVotes = `-- name: DeleteVoterVotes :exec
+WITH deleted AS (
+	DELETE FROM votes
+	WHERE voter = $1
+	RETURNING post_id, value
+)
+UPDATE posts
+SET score = score - d.total
+FROM (SELECT post_id, SUM(value) AS total FROM deleted GROUP BY post_id) d
+WHERE posts.id = d.post_id
+`
+
+func (q *Queries) DeleteVoterVotes(ctx context.Context, voter string) error {
+	_, err := q.db.ExecContext(ctx, deleteVoterVotes, voter)
+	return err
+}
+
+const createVoteBatch = `-- name: createVoteBatch :execresult
+INSERT INTO vote_batches (id)
+VALUES ($1)
+ON CONFLICT (id) DO NOTHING
+`
+
+func (q *Queries) createVoteBatch(ctx context.Context, id string) (sql.Result, error) {
+	return q.db.ExecContext(ctx, createVoteBatch, id)
+}
+
+const pruneVoteBatches = `-- name: PruneVoteBatches :execrows
+DELETE FROM vote_batches
+WHERE applied_at < $1
+`
+
+func (q *Queries) PruneVoteBatches(ctx context.Context, before time.Time) (int64, error) {
+	res, err := q.db.ExecContext(ctx, pruneVoteBatches, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+const createVote = `-- name: createVote :exec
+INSERT INTO votes (post_id, voter, value, created_at)
+SELECT $1::uuid, $2::text, $3::smallint, $4::timestamptz
+WHERE EXISTS (SELECT 1 FROM posts WHERE id = $1)
+`
+
+func (q *Queries) createVote(ctx context.Context, arg web.Vote) error {
+	_, err := q.db.ExecContext(ctx, createVote, arg.PostID, arg.Voter, arg.Value, arg.CreatedAt)
+	return err
+}
+
+const addPostScore = `-- name: addPostScore :exec
+UPDATE posts
+SET score = score + $2
 WHERE id = $1
 `
 
-func (q *Queries) DownvotePost(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, downvotePost, id)
+func (q *Queries) addPostScore(ctx context.Context, id uuid.UUID, delta int) error {
+	_, err := q.db.ExecContext(ctx, addPostScore, id, delta)
 	return err
 }
@@ -14,15 +14,25 @@ type DBTX interface {
 }
 
 func New(db DBTX) *Queries {
-	return &Queries{db: db}
+	return &Queries{db: db, read: db}
 }
 
 type Queries struct {
-	db DBTX
+	db   DBTX
+	read DBTX
 }
 
 func (q *Queries) WithTx(tx *sql.Tx) *Queries {
 	return &Queries{
-		db: tx,
+		db:   tx,
+		read: tx,
+	}
+}
+
+// WithReader returns Queries sending read only queries to read.
+func (q *Queries) WithReader(read DBTX) *Queries {
+	return &Queries{
+		db:   q.db,
+		read: read,
 	}
 }
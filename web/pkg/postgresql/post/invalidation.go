@@ -0,0 +1,71 @@
+package post
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/skale-5/skalogram/web"
+)
+
+const invalidationChannel = "skalogram_invalidations"
+
+// Notifier carries post invalidations over LISTEN/NOTIFY, for deployments
+// without Redis.
+type Notifier struct {
+	db       DBTX
+	connInfo string
+}
+
+// NewNotifier publishes through db and listens on a dedicated connection
+// opened with connInfo.
+func NewNotifier(db DBTX, connInfo string) *Notifier {
+	return &Notifier{
+		db:       db,
+		connInfo: connInfo,
+	}
+}
+
+const notifyInvalidation = `-- name: NotifyInvalidation :exec
+SELECT pg_notify($1, $2)
+`
+
+// Invalidations are published as "<origin> <post id>".
+func (n *Notifier) PublishInvalidation(ctx context.Context, inv web.PostInvalidation) error {
+	_, err := n.db.ExecContext(ctx, notifyInvalidation, invalidationChannel, inv.Origin+" "+inv.ID.String())
+	return err
+}
+
+func (n *Notifier) SubscribeInvalidations(ctx context.Context, handle func(web.PostInvalidation)) error {
+	listener := pq.NewListener(n.connInfo, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("[WARNING] post invalidation listener: %s\n", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(invalidationChannel); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case notification := <-listener.Notify:
+			// nil after the listener reconnected
+			if notification == nil {
+				continue
+			}
+			origin, id, _ := strings.Cut(notification.Extra, " ")
+			postID, err := uuid.Parse(id)
+			if err != nil {
+				log.Printf("[WARNING] invalid post invalidation %q: %s\n", notification.Extra, err)
+				continue
+			}
+			handle(web.PostInvalidation{ID: postID, Origin: origin})
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package post
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/web"
+)
+
+const recordRenderFailure = `-- name: RecordRenderFailure :exec
+INSERT INTO render_failures (
+  post_id, category, message
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT (post_id) DO UPDATE
+SET category = EXCLUDED.category, message = EXCLUDED.message,
+  failures = render_failures.failures + 1, last_failed_at = now()
+`
+
+func (q *Queries) RecordRenderFailure(ctx context.Context, arg web.RecordRenderFailureParams) error {
+	_, err := q.db.ExecContext(ctx, recordRenderFailure, arg.PostID, arg.Category, arg.Message)
+	return err
+}
+
+const getRenderFailure = `-- name: GetRenderFailure :one
+SELECT post_id, category, message, failures, first_failed_at, last_failed_at FROM render_failures
+WHERE post_id = $1
+`
+
+func (q *Queries) GetRenderFailure(ctx context.Context, postID uuid.UUID) (web.RenderFailure, error) {
+	row := q.read.QueryRowContext(ctx, getRenderFailure, postID)
+	var i web.RenderFailure
+	err := row.Scan(
+		&i.PostID,
+		&i.Category,
+		&i.Message,
+		&i.Failures,
+		&i.FirstFailedAt,
+		&i.LastFailedAt,
+	)
+	return i, err
+}
+
+const listRenderFailures = `-- name: ListRenderFailures :many
+SELECT f.post_id, f.category, f.message, f.failures, f.first_failed_at, f.last_failed_at FROM render_failures f
+JOIN posts p ON p.id = f.post_id
+WHERE p.status <> 'removed'
+ORDER BY f.last_failed_at DESC
+`
+
+func (q *Queries) ListRenderFailures(ctx context.Context) ([]web.RenderFailure, error) {
+	rows, err := q.read.QueryContext(ctx, listRenderFailures)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []web.RenderFailure
+	for rows.Next() {
+		var i web.RenderFailure
+		if err := rows.Scan(
+			&i.PostID,
+			&i.Category,
+			&i.Message,
+			&i.Failures,
+			&i.FirstFailedAt,
+			&i.LastFailedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteRenderFailure = `-- name: DeleteRenderFailure :exec
+DELETE FROM render_failures
+WHERE post_id = $1
+`
+
+func (q *Queries) DeleteRenderFailure(ctx context.Context, postID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteRenderFailure, postID)
+	return err
+}
@@ -0,0 +1,134 @@
+package post
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/skale-5/skalogram/web"
+)
+
+type replica struct {
+	name    string
+	db      *sql.DB
+	healthy int32
+}
+
+// ReplicaSet is a DBTX spreading queries over the healthy read replicas in
+// a round robin fashion. Queries go to the primary when no replica is
+// healthy or the context asks for it with web.ReadPrimary, and are retried
+// on the primary when they fail on a replica.
+type ReplicaSet struct {
+	primary  DBTX
+	replicas []*replica
+	next     uint32
+}
+
+func NewReplicaSet(primary DBTX) *ReplicaSet {
+	return &ReplicaSet{
+		primary: primary,
+	}
+}
+
+// AddReplica registers a replica, it only receives queries once a health
+// check succeeded.
+func (rs *ReplicaSet) AddReplica(name string, db *sql.DB) {
+	rs.replicas = append(rs.replicas, &replica{
+		name: name,
+		db:   db,
+	})
+}
+
+func (rs *ReplicaSet) pick(ctx context.Context) (db DBTX, replica bool) {
+	if web.ReadsPrimary(ctx) {
+		return rs.primary, false
+	}
+	n := len(rs.replicas)
+	start := atomic.AddUint32(&rs.next, 1)
+	for i := 0; i < n; i++ {
+		r := rs.replicas[(int(start)+i)%n]
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			return r.db, true
+		}
+	}
+	return rs.primary, false
+}
+
+// retry tells whether a query failing on a replica is worth running on the
+// primary.
+func retry(ctx context.Context, replica bool, err error) bool {
+	return replica && err != nil && ctx.Err() == nil
+}
+
+// RunHealthChecks pings every replica each interval until ctx is done.
+func (rs *ReplicaSet) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, r := range rs.replicas {
+			rs.check(ctx, r, interval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (rs *ReplicaSet) check(ctx context.Context, r *replica, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var healthy int32 = 1
+	if err := r.db.PingContext(ctx); err != nil {
+		healthy = 0
+	}
+	if atomic.SwapInt32(&r.healthy, healthy) != healthy {
+		if healthy == 1 {
+			log.Printf("postgres replica %s is healthy, routing reads to it\n", r.name)
+		} else {
+			log.Printf("[WARNING] postgres replica %s is unhealthy, routing reads elsewhere\n", r.name)
+		}
+	}
+}
+
+func (rs *ReplicaSet) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db, replica := rs.pick(ctx)
+	res, err := db.ExecContext(ctx, query, args...)
+	if retry(ctx, replica, err) {
+		return rs.primary.ExecContext(ctx, query, args...)
+	}
+	return res, err
+}
+
+func (rs *ReplicaSet) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	db, replica := rs.pick(ctx)
+	stmt, err := db.PrepareContext(ctx, query)
+	if retry(ctx, replica, err) {
+		return rs.primary.PrepareContext(ctx, query)
+	}
+	return stmt, err
+}
+
+func (rs *ReplicaSet) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	db, replica := rs.pick(ctx)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if retry(ctx, replica, err) {
+		return rs.primary.QueryContext(ctx, query, args...)
+	}
+	return rows, err
+}
+
+// QueryRowContext retries when the query fails, not when it finds no row.
+func (rs *ReplicaSet) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	db, replica := rs.pick(ctx)
+	row := db.QueryRowContext(ctx, query, args...)
+	if retry(ctx, replica, row.Err()) {
+		return rs.primary.QueryRowContext(ctx, query, args...)
+	}
+	return row
+}
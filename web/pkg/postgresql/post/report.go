@@ -0,0 +1,95 @@
+package post
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/web"
+)
+
+const createReport = `-- name: createReport :exec
+INSERT INTO reports (
+  post_id, reporter, reporter_ip, reason, details
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+ON CONFLICT (post_id, reporter) WHERE resolved_at IS NULL DO NOTHING
+`
+
+func (q *Queries) createReport(ctx context.Context, arg web.CreateReportParams) error {
+	_, err := q.db.ExecContext(ctx, createReport, arg.PostID, arg.Reporter, arg.ReporterIP, arg.Reason, arg.Details)
+	return err
+}
+
+const countOpenReports = `-- name: countOpenReports :one
+SELECT COUNT(DISTINCT reporter_ip) FROM reports
+WHERE post_id = $1 AND resolved_at IS NULL
+`
+
+func (q *Queries) countOpenReports(ctx context.Context, postID uuid.UUID) (int, error) {
+	row := q.db.QueryRowContext(ctx, countOpenReports, postID)
+	var count int
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countReportsSince = `-- name: CountReportsSince :one
+SELECT COUNT(*) FROM reports
+WHERE reporter_ip = $1 AND created_at >= $2
+`
+
+func (q *Queries) CountReportsSince(ctx context.Context, reporterIP string, since time.Time) (int, error) {
+	row := q.db.QueryRowContext(ctx, countReportsSince, reporterIP, since)
+	var count int
+	err := row.Scan(&count)
+	return count, err
+}
+
+const listOpenReports = `-- name: ListOpenReports :many
+SELECT id, post_id, reporter, reporter_ip, reason, details, created_at FROM reports
+WHERE resolved_at IS NULL
+ORDER BY created_at ASC
+`
+
+func (q *Queries) ListOpenReports(ctx context.Context) ([]web.Report, error) {
+	rows, err := q.read.QueryContext(ctx, listOpenReports)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []web.Report
+	for rows.Next() {
+		var i web.Report
+		if err := rows.Scan(
+			&i.ID,
+			&i.PostID,
+			&i.Reporter,
+			&i.ReporterIP,
+			&i.Reason,
+			&i.Details,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const resolveReports = `-- name: ResolveReports :exec
+UPDATE reports
+SET resolved_at = now(), resolution = $2, moderator_id = $3
+WHERE post_id = $1 AND resolved_at IS NULL
+`
+
+func (q *Queries) ResolveReports(ctx context.Context, arg web.ResolveReportsParams) error {
+	_, err := q.db.ExecContext(ctx, resolveReports, arg.PostID, arg.Resolution, arg.ModeratorID)
+	return err
+}
@@ -0,0 +1,130 @@
+package post
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/web"
+)
+
+type Store struct {
+	*Queries
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		Queries: New(db),
+		db:      db,
+	}
+}
+
+// WithReader returns a Store sending read only queries to read, writes
+// and transactions keep going to the primary.
+func (s *Store) WithReader(read DBTX) *Store {
+	return &Store{
+		Queries: s.Queries.WithReader(read),
+		db:      s.db,
+	}
+}
+
+func (s *Store) execTx(ctx context.Context, fn func(*Queries) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(s.WithTx(tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%s (rollback failed: %s)", err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) CreatePendingPost(ctx context.Context, arg web.CreatePostParams) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		if err := q.createPendingPost(ctx, arg); err != nil {
+			return err
+		}
+		return q.createOutboxEntry(ctx, arg)
+	})
+}
+
+func (s *Store) PublishPost(ctx context.Context, id uuid.UUID) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		if err := q.publishPost(ctx, id); err != nil {
+			return err
+		}
+		return q.deleteOutboxEntry(ctx, id)
+	})
+}
+
+func (s *Store) DiscardPost(ctx context.Context, id uuid.UUID) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		if err := q.deleteOutboxEntry(ctx, id); err != nil {
+			return err
+		}
+		return q.discardPendingPost(ctx, id)
+	})
+}
+
+// ApplyVoteBatch persists a batch of buffered votes exactly once, batches
+// already applied are ignored.
+func (s *Store) ApplyVoteBatch(ctx context.Context, batch web.VoteBatch) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		res, err := q.createVoteBatch(ctx, batch.ID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+
+		deltas := make(map[uuid.UUID]int)
+		for _, vote := range batch.Votes {
+			if err := q.createVote(ctx, vote); err != nil {
+				return err
+			}
+			deltas[vote.PostID] += vote.Value
+		}
+		for id, delta := range deltas {
+			if err := q.addPostScore(ctx, id, delta); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) CreateReport(ctx context.Context, arg web.CreateReportParams) (int, error) {
+	var count int
+	err := s.execTx(ctx, func(q *Queries) error {
+		if err := q.createReport(ctx, arg); err != nil {
+			return err
+		}
+		var err error
+		count, err = q.countOpenReports(ctx, arg.PostID)
+		return err
+	})
+	return count, err
+}
+
+func (s *Store) RemoveReportedPost(ctx context.Context, arg web.ModeratePostParams) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		if err := q.DeletePost(ctx, arg); err != nil {
+			return err
+		}
+		return q.ResolveReports(ctx, web.ResolveReportsParams{
+			PostID:      arg.ID,
+			Resolution:  web.ReportResolutionRemoved,
+			ModeratorID: arg.ModeratorID,
+		})
+	})
+}
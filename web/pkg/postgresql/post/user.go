@@ -0,0 +1,151 @@
+package post
+
+import (
+	"context"
+
+	"github.com/skale-5/skalogram/web"
+)
+
+const upsertUser = `-- name: UpsertUser :exec
+INSERT INTO users (
+  name, role, password_hash
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT (name) DO UPDATE
+SET role = EXCLUDED.role, password_hash = EXCLUDED.password_hash
+`
+
+func (q *Queries) UpsertUser(ctx context.Context, arg web.User) error {
+	_, err := q.db.ExecContext(ctx, upsertUser, arg.Name, arg.Role, arg.PasswordHash)
+	return err
+}
+
+const getUser = `-- name: GetUser :one
+SELECT name, role, password_hash, created_at FROM users
+WHERE name = $1 LIMIT 1
+`
+
+func (q *Queries) GetUser(ctx context.Context, name string) (web.User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, name)
+	var i web.User
+	err := row.Scan(
+		&i.Name,
+		&i.Role,
+		&i.PasswordHash,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT name, role, password_hash, created_at FROM users
+ORDER BY name ASC
+`
+
+func (q *Queries) ListUsers(ctx context.Context) ([]web.User, error) {
+	rows, err := q.read.QueryContext(ctx, listUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []web.User
+	for rows.Next() {
+		var i web.User
+		if err := rows.Scan(
+			&i.Name,
+			&i.Role,
+			&i.PasswordHash,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteUser = `-- name: DeleteUser :exec
+DELETE FROM users
+WHERE name = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, name string) error {
+	_, err := q.db.ExecContext(ctx, deleteUser, name)
+	return err
+}
+
+const createBan = `-- name: CreateBan :exec
+INSERT INTO ip_bans (
+  ip, reason, moderator_id
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT (ip) DO UPDATE
+SET reason = EXCLUDED.reason, moderator_id = EXCLUDED.moderator_id
+`
+
+func (q *Queries) CreateBan(ctx context.Context, arg web.Ban) error {
+	_, err := q.db.ExecContext(ctx, createBan, arg.IP, arg.Reason, arg.ModeratorID)
+	return err
+}
+
+const deleteBan = `-- name: DeleteBan :exec
+DELETE FROM ip_bans
+WHERE ip = $1
+`
+
+func (q *Queries) DeleteBan(ctx context.Context, ip string) error {
+	_, err := q.db.ExecContext(ctx, deleteBan, ip)
+	return err
+}
+
+const isBanned = `-- name: IsBanned :one
+SELECT EXISTS (SELECT 1 FROM ip_bans WHERE ip = $1)
+`
+
+func (q *Queries) IsBanned(ctx context.Context, ip string) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isBanned, ip)
+	var banned bool
+	err := row.Scan(&banned)
+	return banned, err
+}
+
+const listBans = `-- name: ListBans :many
+SELECT ip, reason, moderator_id, created_at FROM ip_bans
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListBans(ctx context.Context) ([]web.Ban, error) {
+	rows, err := q.read.QueryContext(ctx, listBans)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []web.Ban
+	for rows.Next() {
+		var i web.Ban
+		if err := rows.Scan(
+			&i.IP,
+			&i.Reason,
+			&i.ModeratorID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
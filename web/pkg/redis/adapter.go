@@ -9,18 +9,66 @@ import (
 	"github.com/skale-5/skalogram/web"
 )
 
+const (
+	defaultKeyPrefix = "skalogram"
+	defaultNamespace = "default"
+)
+
+// Client keys posts by "<prefix>:post:<namespace>:{<id>}:<kind>[:<variant>]",
+// the namespace being the web.RenderNamespace of the renderings. The keys of
+// the variants of a post are indexed in a set, to delete them with the post.
+// The other keys and the invalidation channel are under prefix too.
 type Client struct {
-	rc *redis.Client
+	rc         redis.UniversalClient
+	prefix     string
+	namespace  string
+	namespaces *namespaceCache
 }
 
-func NewClient(addr string) *Client {
-	rc := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: "", // no password set
-		DB:       0,  // use default DB
-	})
+func NewClient(opts Options) (*Client, error) {
+	rc, err := newUniversalClient(opts)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Client{rc: rc}
+	return &Client{
+		rc:         rc,
+		prefix:     defaultKeyPrefix,
+		namespace:  defaultNamespace,
+		namespaces: &namespaceCache{},
+	}, nil
+}
+
+// WithKeyspace returns a Client sharing the connections of c and keying
+// every entry under prefix, posts under namespace.
+func (c *Client) WithKeyspace(prefix, namespace string) *Client {
+	return &Client{
+		rc:         c.rc,
+		prefix:     prefix,
+		namespace:  namespace,
+		namespaces: &namespaceCache{},
+	}
+}
+
+func (c *Client) namespacePattern(namespace string) string {
+	return c.prefix + ":post:" + namespace + ":*"
+}
+
+// The post ID is a hash tag so the entries of a post share a cluster slot.
+func (c *Client) postKey(key web.PostCacheKey) string {
+	k := c.postKeyBase(key.ID) + string(key.Kind)
+	if key.Variant != "" {
+		k += ":" + key.Variant
+	}
+	return k
+}
+
+func (c *Client) postKeyBase(id uuid.UUID) string {
+	return c.prefix + ":post:" + c.namespace + ":{" + id.String() + "}:"
+}
+
+func (c *Client) variantsKey(id uuid.UUID) string {
+	return c.postKeyBase(id) + "variants"
 }
 
 func (c *Client) Ping(ctx context.Context) error {
@@ -31,16 +79,29 @@ func (c *Client) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (c *Client) CachePost(ctx context.Context, id uuid.UUID, content interface{}, ttl time.Duration) (interface{}, error) {
-	err := c.rc.Set(ctx, id.String(), content, ttl).Err()
+// CachePost checks whether the entry exists in the transaction writing it,
+// sparing a round trip.
+func (c *Client) CachePost(ctx context.Context, key web.PostCacheKey, entry []byte, ttl time.Duration) (bool, error) {
+	var exists *redis.IntCmd
+	_, err := c.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		exists = pipe.Exists(ctx, c.postKey(key))
+		pipe.Set(ctx, c.postKey(key), entry, ttl)
+		if key.Variant != "" {
+			pipe.SAdd(ctx, c.variantsKey(key.ID), c.postKey(key))
+			if ttl > 0 {
+				pipe.Expire(ctx, c.variantsKey(key.ID), ttl)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	return content, nil
+	return exists.Val() > 0, nil
 }
 
-func (c *Client) GetPost(ctx context.Context, id uuid.UUID) (interface{}, error) {
-	val, err := c.rc.Get(ctx, id.String()).Result()
+func (c *Client) GetPost(ctx context.Context, key web.PostCacheKey) ([]byte, error) {
+	val, err := c.rc.Get(ctx, c.postKey(key)).Bytes()
 	if err != nil && err != redis.Nil {
 		return nil, err
 	}
@@ -49,3 +110,15 @@ func (c *Client) GetPost(ctx context.Context, id uuid.UUID) (interface{}, error)
 	}
 	return val, nil
 }
+
+func (c *Client) DeletePost(ctx context.Context, id uuid.UUID) error {
+	variants, err := c.rc.SMembers(ctx, c.variantsKey(id)).Result()
+	if err != nil {
+		return err
+	}
+	keys := append(variants, c.variantsKey(id))
+	for _, kind := range web.PostCacheEntryKinds {
+		keys = append(keys, c.postKey(web.PostCacheKey{ID: id, Kind: kind}))
+	}
+	return c.rc.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/web"
+)
+
+func (c *Client) invalidationChannel() string {
+	return c.prefix + ":invalidations"
+}
+
+// Invalidations are published as "<origin> <post id>".
+func (c *Client) PublishInvalidation(ctx context.Context, inv web.PostInvalidation) error {
+	return c.rc.Publish(ctx, c.invalidationChannel(), inv.Origin+" "+inv.ID.String()).Err()
+}
+
+func (c *Client) SubscribeInvalidations(ctx context.Context, handle func(web.PostInvalidation)) error {
+	pubsub := c.rc.Subscribe(ctx, c.invalidationChannel())
+	defer pubsub.Close()
+
+	// wait for the subscription to be confirmed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			inv, err := parseInvalidation(msg.Payload)
+			if err != nil {
+				log.Printf("[WARNING] invalid post invalidation %q: %s\n", msg.Payload, err)
+				continue
+			}
+			handle(inv)
+		}
+	}
+}
+
+func parseInvalidation(payload string) (web.PostInvalidation, error) {
+	origin, id, _ := strings.Cut(payload, " ")
+	postID, err := uuid.Parse(id)
+	if err != nil {
+		return web.PostInvalidation{}, err
+	}
+	return web.PostInvalidation{ID: postID, Origin: origin}, nil
+}
@@ -0,0 +1,34 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+var releaseLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (c *Client) lockKey(key string) string {
+	return c.prefix + ":lock:" + key
+}
+
+func (c *Client) AcquireLock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	token := uuid.New().String()
+	acquired, err := c.rc.SetNX(ctx, c.lockKey(key), token, ttl).Result()
+	if err != nil {
+		return "", false, err
+	}
+	return token, acquired, nil
+}
+
+// ReleaseLock only releases the lock when it is still held with token.
+func (c *Client) ReleaseLock(ctx context.Context, key string, token string) error {
+	return releaseLock.Run(ctx, c.rc, []string{c.lockKey(key)}, token).Err()
+}
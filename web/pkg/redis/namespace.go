@@ -0,0 +1,133 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/skale-5/skalogram/web"
+)
+
+const (
+	scanCount = 500
+	// namespacesTTL bounds how often Namespaces scans the keyspace.
+	namespacesTTL = time.Minute
+)
+
+type namespaceCache struct {
+	mu         sync.Mutex
+	namespaces []web.CacheNamespace
+	expiresAt  time.Time
+}
+
+func (c *Client) Namespace() string {
+	return c.namespace
+}
+
+// scan calls fn with batches of keys matching pattern, on every master node
+// in cluster mode. fn may be called concurrently for different nodes.
+func (c *Client) scan(ctx context.Context, pattern string, fn func(keys []string) error) error {
+	scanNode := func(ctx context.Context, node redis.Cmdable) error {
+		var cursor uint64
+		for {
+			keys, next, err := node.Scan(ctx, cursor, pattern, scanCount).Result()
+			if err != nil {
+				return err
+			}
+			if len(keys) > 0 {
+				if err := fn(keys); err != nil {
+					return err
+				}
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+
+	if cluster, ok := c.rc.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node)
+		})
+	}
+	return scanNode(ctx, c.rc)
+}
+
+// Namespaces scans the whole keyspace, at most once per namespacesTTL, it is
+// meant for administration only.
+func (c *Client) Namespaces(ctx context.Context) ([]web.CacheNamespace, error) {
+	c.namespaces.mu.Lock()
+	defer c.namespaces.mu.Unlock()
+	if time.Now().Before(c.namespaces.expiresAt) {
+		return c.namespaces.namespaces, nil
+	}
+	namespaces, err := c.scanNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.namespaces.namespaces = namespaces
+	c.namespaces.expiresAt = time.Now().Add(namespacesTTL)
+	return namespaces, nil
+}
+
+func (c *Client) scanNamespaces(ctx context.Context) ([]web.CacheNamespace, error) {
+	var mu sync.Mutex
+	keys := make(map[string]int)
+	postPrefix := c.prefix + ":post:"
+	err := c.scan(ctx, c.namespacePattern("*"), func(batch []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, key := range batch {
+			namespace, _, _ := strings.Cut(strings.TrimPrefix(key, postPrefix), ":")
+			keys[namespace]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := make([]web.CacheNamespace, 0, len(keys))
+	for name, n := range keys {
+		namespaces = append(namespaces, web.CacheNamespace{Name: name, Keys: n})
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces, nil
+}
+
+// PurgeNamespace deletes keys one by one in a pipeline, keys of a batch may
+// belong to different cluster slots.
+func (c *Client) PurgeNamespace(ctx context.Context, namespace string) (int, error) {
+	if namespace == "" || strings.ContainsAny(namespace, "*?[]\\:") {
+		return 0, fmt.Errorf("%w: %q", web.ErrInvalidCacheNamespace, namespace)
+	}
+	defer func() {
+		c.namespaces.mu.Lock()
+		c.namespaces.expiresAt = time.Time{}
+		c.namespaces.mu.Unlock()
+	}()
+	var mu sync.Mutex
+	deleted := 0
+	err := c.scan(ctx, c.namespacePattern(namespace), func(batch []string) error {
+		cmds, err := c.rc.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range batch {
+				pipe.Del(ctx, key)
+			}
+			return nil
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		for _, cmd := range cmds {
+			deleted += int(cmd.(*redis.IntCmd).Val())
+		}
+		return err
+	})
+	return deleted, err
+}
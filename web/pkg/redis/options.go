@@ -0,0 +1,108 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type Options struct {
+	// URL is a redis:// or rediss:// URL, when set it takes precedence over
+	// Addrs, Username, Password, DB and TLS.
+	URL string
+	// Addrs are the host:port of the server, or of the sentinels or cluster
+	// seed nodes.
+	Addrs []string
+	// Username enables ACL authentication along with Password.
+	Username string
+	Password string
+	DB       int
+
+	TLS bool
+	// TLSCACert is the path of a PEM bundle trusted instead of the system
+	// certificate pool.
+	TLSCACert string
+
+	// SentinelMaster enables Sentinel failover on the named master, Addrs
+	// being the sentinels.
+	SentinelMaster   string
+	SentinelPassword string
+
+	Cluster bool
+}
+
+func (opts Options) tlsConfig() (*tls.Config, error) {
+	if !opts.TLS {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.TLSCACert != "" {
+		pem, err := os.ReadFile(opts.TLSCACert)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read redis CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", opts.TLSCACert)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+func newUniversalClient(opts Options) (redis.UniversalClient, error) {
+	if opts.URL != "" {
+		u, err := redis.ParseURL(opts.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis URL: %w", err)
+		}
+		opts.Addrs = []string{u.Addr}
+		opts.Username = u.Username
+		opts.Password = u.Password
+		opts.DB = u.DB
+		opts.TLS = opts.TLS || u.TLSConfig != nil
+	}
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("no redis address configured")
+	}
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case opts.SentinelMaster != "" && opts.Cluster:
+		return nil, errors.New("redis sentinel and cluster modes are exclusive")
+	case opts.SentinelMaster != "":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       opts.SentinelMaster,
+			SentinelAddrs:    opts.Addrs,
+			SentinelPassword: opts.SentinelPassword,
+			Username:         opts.Username,
+			Password:         opts.Password,
+			DB:               opts.DB,
+			TLSConfig:        tlsConfig,
+		}), nil
+	case opts.Cluster:
+		if opts.DB != 0 {
+			return nil, errors.New("redis cluster only supports database 0")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     opts.Addrs,
+			Username:  opts.Username,
+			Password:  opts.Password,
+			TLSConfig: tlsConfig,
+		}), nil
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:      opts.Addrs[0],
+		Username:  opts.Username,
+		Password:  opts.Password,
+		DB:        opts.DB,
+		TLSConfig: tlsConfig,
+	}), nil
+}
@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/skale-5/skalogram/web"
+)
+
+// dequeueTimeout bounds a blocking pop, so that the workers notice when
+// their context is done and a pop does not hold the circuit breaker probe.
+const dequeueTimeout = time.Second
+
+// the keys of the queue share a hash slot, for enqueueRender to run on a
+// cluster.
+func (c *Client) renderQueueKey() string {
+	return c.prefix + ":render:{" + c.namespace + "}:queue"
+}
+
+func (c *Client) renderPendingKey(job web.RenderJob) string {
+	return c.prefix + ":render:{" + c.namespace + "}:pending:" + job.Key()
+}
+
+var enqueueRender = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return 0
+end
+if redis.call('LLEN', KEYS[1]) >= tonumber(ARGV[3]) then
+	return -1
+end
+redis.call('SET', KEYS[2], 1, 'PX', ARGV[2])
+redis.call('LPUSH', KEYS[1], ARGV[1])
+return 1
+`)
+
+// EnqueueRender pushes job to the queue list unless it holds maxLength jobs
+// already, a pending key marks it as queued until it is done or expires.
+func (c *Client) EnqueueRender(ctx context.Context, job web.RenderJob, pendingTTL time.Duration, maxLength int) (bool, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return false, err
+	}
+	keys := []string{c.renderQueueKey(), c.renderPendingKey(job)}
+	queued, err := enqueueRender.Run(ctx, c.rc, keys, data, pendingTTL.Milliseconds(), maxLength).Int()
+	if err != nil {
+		return false, err
+	}
+	if queued < 0 {
+		return false, web.ErrRenderQueueFull
+	}
+	return queued == 1, nil
+}
+
+func (c *Client) DequeueRender(ctx context.Context) (web.RenderJob, error) {
+	vals, err := c.rc.BRPop(ctx, dequeueTimeout, c.renderQueueKey()).Result()
+	if err == redis.Nil {
+		if ctx.Err() != nil {
+			return web.RenderJob{}, ctx.Err()
+		}
+		return web.RenderJob{}, web.ErrRenderQueueEmpty
+	}
+	if err != nil {
+		return web.RenderJob{}, err
+	}
+	var job web.RenderJob
+	err = json.Unmarshal([]byte(vals[1]), &job)
+	return job, err
+}
+
+func (c *Client) DoneRender(ctx context.Context, job web.RenderJob) error {
+	return c.rc.Del(ctx, c.renderPendingKey(job)).Err()
+}
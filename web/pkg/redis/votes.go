@@ -0,0 +1,178 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/web"
+)
+
+// Vote buffer keys share a hash tag so the scripts below only touch a
+// single slot.
+const (
+	pendingVotesKey     = "pending:events"
+	pendingScoresKey    = "pending:scores"
+	flushingVotesKey    = "flushing:events"
+	flushingScoresKey   = "flushing:scores"
+	flushingBatchIDKey  = "flushing:id"
+	flushingAttemptsKey = "flushing:attempts"
+	deadVotesKey        = "dead:events"
+)
+
+func (c *Client) votesKey(name string) string {
+	return "{" + c.prefix + ":votes}:" + name
+}
+
+func (c *Client) votesKeys(names ...string) []string {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = c.votesKey(name)
+	}
+	return keys
+}
+
+// claimVoteBatch moves the pending votes to the flushing keys, unless a
+// previous batch is still waiting for its ack, and returns the batch ID. A
+// batch claimed ARGV[2] times already is moved to the dead letter list
+// instead, the number of its votes being returned as well.
+var claimVoteBatch = redis.NewScript(`
+local dead = 0
+if redis.call('EXISTS', KEYS[5]) == 1 then
+	if redis.call('INCR', KEYS[6]) <= tonumber(ARGV[2]) then
+		return {redis.call('GET', KEYS[5]), 0}
+	end
+	local votes = redis.call('LRANGE', KEYS[3], 0, -1)
+	for i = 1, #votes do
+		redis.call('RPUSH', KEYS[7], votes[i])
+	end
+	dead = #votes
+	redis.call('DEL', KEYS[3], KEYS[4], KEYS[5], KEYS[6])
+end
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return {false, dead}
+end
+redis.call('RENAME', KEYS[1], KEYS[3])
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	redis.call('RENAME', KEYS[2], KEYS[4])
+end
+redis.call('SET', KEYS[5], ARGV[1])
+redis.call('SET', KEYS[6], 1)
+return {ARGV[1], dead}
+`)
+
+// replayDeadVotes moves the dead letters back to the pending votes.
+var replayDeadVotes = redis.NewScript(`
+local votes = redis.call('LRANGE', KEYS[1], 0, -1)
+for i = 1, #votes do
+	local vote = cjson.decode(votes[i])
+	redis.call('RPUSH', KEYS[2], votes[i])
+	redis.call('HINCRBY', KEYS[3], vote['post_id'], vote['value'])
+end
+redis.call('DEL', KEYS[1])
+return #votes
+`)
+
+var ackVoteBatch = redis.NewScript(`
+if redis.call('GET', KEYS[3]) == ARGV[1] then
+	redis.call('DEL', KEYS[1], KEYS[2], KEYS[3], KEYS[4])
+end
+return 0
+`)
+
+type bufferedVote struct {
+	PostID    uuid.UUID `json:"post_id"`
+	Voter     string    `json:"voter"`
+	Value     int       `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c *Client) BufferVote(ctx context.Context, vote web.Vote) error {
+	data, err := json.Marshal(bufferedVote(vote))
+	if err != nil {
+		return err
+	}
+	_, err = c.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, c.votesKey(pendingVotesKey), data)
+		pipe.HIncrBy(ctx, c.votesKey(pendingScoresKey), vote.PostID.String(), int64(vote.Value))
+		return nil
+	})
+	return err
+}
+
+// PendingScores counts the claimed votes until their batch is acked, the
+// flusher acking a batch right after applying it.
+func (c *Client) PendingScores(ctx context.Context) (map[uuid.UUID]int, error) {
+	var pending, flushing *redis.StringStringMapCmd
+	_, err := c.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pending = pipe.HGetAll(ctx, c.votesKey(pendingScoresKey))
+		flushing = pipe.HGetAll(ctx, c.votesKey(flushingScoresKey))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	scores := make(map[uuid.UUID]int)
+	for _, vals := range []map[string]string{pending.Val(), flushing.Val()} {
+		for k, v := range vals {
+			id, err := uuid.Parse(k)
+			if err != nil {
+				continue
+			}
+			delta, err := strconv.Atoi(v)
+			if err != nil {
+				continue
+			}
+			scores[id] += delta
+		}
+	}
+	return scores, nil
+}
+
+func (c *Client) ClaimVoteBatch(ctx context.Context, maxAttempts int) (web.VoteBatch, error) {
+	keys := c.votesKeys(pendingVotesKey, pendingScoresKey, flushingVotesKey, flushingScoresKey, flushingBatchIDKey, flushingAttemptsKey, deadVotesKey)
+	res, err := claimVoteBatch.Run(ctx, c.rc, keys, uuid.New().String(), maxAttempts).Slice()
+	if err != nil {
+		return web.VoteBatch{}, err
+	}
+	dead, _ := res[1].(int64)
+	batchID, ok := res[0].(string)
+	if !ok {
+		return web.VoteBatch{DeadVotes: int(dead)}, nil
+	}
+
+	vals, err := c.rc.LRange(ctx, c.votesKey(flushingVotesKey), 0, -1).Result()
+	if err != nil {
+		return web.VoteBatch{}, err
+	}
+	batch := web.VoteBatch{
+		ID:        batchID,
+		Votes:     make([]web.Vote, 0, len(vals)),
+		DeadVotes: int(dead),
+	}
+	for _, v := range vals {
+		var vote bufferedVote
+		if err := json.Unmarshal([]byte(v), &vote); err != nil {
+			return web.VoteBatch{}, err
+		}
+		batch.Votes = append(batch.Votes, web.Vote(vote))
+	}
+	return batch, nil
+}
+
+func (c *Client) AckVoteBatch(ctx context.Context, batchID string) error {
+	keys := c.votesKeys(flushingVotesKey, flushingScoresKey, flushingBatchIDKey, flushingAttemptsKey)
+	return ackVoteBatch.Run(ctx, c.rc, keys, batchID).Err()
+}
+
+func (c *Client) DeadVotes(ctx context.Context) (int64, error) {
+	return c.rc.LLen(ctx, c.votesKey(deadVotesKey)).Result()
+}
+
+func (c *Client) ReplayDeadVotes(ctx context.Context) (int64, error) {
+	keys := c.votesKeys(deadVotesKey, pendingVotesKey, pendingScoresKey)
+	return replayDeadVotes.Run(ctx, c.rc, keys).Int64()
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -58,9 +59,37 @@ func (c *Client) Get(ctx context.Context, object *web.ObjectPath) (io.ReadCloser
 		Bucket: aws.String(object.Bucket),
 		Key:    aws.String(object.Path),
 	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, fmt.Errorf("failed to get s3 object %s: %w", object.URL(), web.ErrObjectNotFound)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get s3 object %s: %s", object.URL(), err)
 	}
 
 	return out.Body, nil
 }
+
+func (c *Client) Exists(ctx context.Context, object *web.ObjectPath) (bool, error) {
+	_, err := c.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(object.Bucket),
+		Key:    aws.String(object.Path),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to head s3 object %s: %s", object.URL(), err)
+	}
+	return true, nil
+}
+
+func (c *Client) Delete(ctx context.Context, object *web.ObjectPath) error {
+	_, err := c.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(object.Bucket),
+		Key:    aws.String(object.Path),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete s3 object %s: %s", object.URL(), err)
+	}
+	return nil
+}
@@ -5,66 +5,225 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"image"
 	"io"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/qeesung/image2ascii/convert"
-	"github.com/robert-nix/ansihtml"
+	"github.com/skale-5/skalogram/render"
 )
 
+type PostStatus string
+
+const (
+	PostStatusPending       PostStatus = "pending"
+	PostStatusPublished     PostStatus = "published"
+	PostStatusHidden        PostStatus = "hidden"
+	PostStatusPendingReview PostStatus = "pending_review"
+	PostStatusRemoved       PostStatus = "removed"
+)
+
+// PostModerationStatuses are the statuses a moderator may move a post to.
+var PostModerationStatuses = []PostStatus{
+	PostStatusPublished,
+	PostStatusHidden,
+	PostStatusPendingReview,
+	PostStatusRemoved,
+}
+
+func (ps PostStatus) Moderable() bool {
+	for _, status := range PostModerationStatuses {
+		if ps == status {
+			return true
+		}
+	}
+	return false
+}
+
 type Post struct {
-	ID        uuid.UUID
-	Score     int
+	ID           uuid.UUID
+	Score        int
+	ImgUrl       string
+	Status       PostStatus
+	StatusReason string
+	ModeratorID  string
+	Author       string
+	// AuthorIP is the address the post was uploaded from, bans are keyed
+	// by it.
+	AuthorIP  string
+	CreatedAt time.Time
+}
+
+// OutboxEntry tracks a pending post whose object upload has not been
+// confirmed yet.
+type OutboxEntry struct {
+	ID        int64
+	PostID    uuid.UUID
 	ImgUrl    string
 	CreatedAt time.Time
 }
 
-func GenerateAscii(file io.ReadCloser) (string, error) {
-	img, _, err := image.Decode(file)
-	if err != nil {
-		return "", err
-	}
+// RendererVersion must be bumped whenever the renderings change without
+// their options changing, so cached renderings are not served anymore.
+const RendererVersion = 6
 
-	converter := convert.NewImageConverter()
-	ascii := converter.Image2ASCIIString(img, &convert.Options{
-		FixedWidth:  55,
-		FixedHeight: 20,
-		Ratio:       2,
-		Colored:     true,
-	})
+// RenderNamespace identifies the renderings of the current renderer version
+// with the server default options, cached entries are keyed by it.
+func RenderNamespace(defaults render.Options) string {
+	return fmt.Sprintf("v%d-%s", RendererVersion, defaults.Hash())
+}
 
-	html := ansihtml.ConvertToHTML([]byte(ascii))
-	return string(html), nil
+// GenerateAscii renders an image, every frame of animated GIFs.
+func GenerateAscii(file io.Reader, opts render.Options) (*render.Canvas, error) {
+	return render.Decode(file, opts)
 }
 
 type CreatePostParams struct {
-	ID     uuid.UUID
-	ImgUrl string
+	ID       uuid.UUID
+	ImgUrl   string
+	Author   string
+	AuthorIP string
+}
+
+type SetPostStatusParams struct {
+	ID          uuid.UUID
+	Status      PostStatus
+	Reason      string
+	ModeratorID string
+}
+
+type ModeratePostParams struct {
+	ID          uuid.UUID
+	Reason      string
+	ModeratorID string
+}
+
+type Vote struct {
+	PostID    uuid.UUID
+	Voter     string
+	Value     int
+	CreatedAt time.Time
+}
+
+// VoteBatch is a set of buffered votes handed off for persistence. Its ID
+// makes applying the batch idempotent.
+type VoteBatch struct {
+	ID    string
+	Votes []Vote
+	// DeadVotes counts the votes of the previous batch this claim set aside
+	// as dead letters.
+	DeadVotes int
+}
+
+type VotePostParams struct {
+	ID    uuid.UUID
+	Voter string
+}
+
+type ScorePoint struct {
+	At    time.Time `json:"at"`
+	Score int       `json:"score"`
+}
+
+type readPrimaryKey struct{}
+
+// ReadPrimary returns a context whose reads go to the primary database
+// rather than to a replica, for a client to read its own writes.
+func ReadPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readPrimaryKey{}, true)
+}
+
+func ReadsPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(readPrimaryKey{}).(bool)
+	return primary
 }
 
 type PostDatabaseAdapter interface {
 	CreateTable(ctx context.Context) error
 	CreatePost(ctx context.Context, arg CreatePostParams) (sql.Result, error)
-	DeletePost(ctx context.Context, id uuid.UUID) error
+	CreatePendingPost(ctx context.Context, arg CreatePostParams) error
+	PublishPost(ctx context.Context, id uuid.UUID) error
+	DiscardPost(ctx context.Context, id uuid.UUID) error
+	ListOutbox(ctx context.Context, limit int) ([]OutboxEntry, error)
+	DeletePost(ctx context.Context, arg ModeratePostParams) error
+	RestorePost(ctx context.Context, arg ModeratePostParams) error
+	SetPostStatus(ctx context.Context, arg SetPostStatusParams) error
 	GetPost(ctx context.Context, id uuid.UUID) (Post, error)
 	ListPosts(ctx context.Context) ([]Post, error)
-	UpvotePost(ctx context.Context, id uuid.UUID) error
-	DownvotePost(ctx context.Context, id uuid.UUID) error
+	ListPostsByStatus(ctx context.Context, status PostStatus) ([]Post, error)
+	UpvotePost(ctx context.Context, arg VotePostParams) error
+	DownvotePost(ctx context.Context, arg VotePostParams) error
+	ListScoreHistory(ctx context.Context, id uuid.UUID) ([]ScorePoint, error)
+	RecomputeScores(ctx context.Context) error
+	DeleteVoterVotes(ctx context.Context, voter string) error
+	ApplyVoteBatch(ctx context.Context, batch VoteBatch) error
+	// PruneVoteBatches forgets the batches applied before a time, and
+	// returns how many were forgotten.
+	PruneVoteBatches(ctx context.Context, before time.Time) (int64, error)
 }
 
+var (
+	ErrPostNotFound      = errors.New("post not found")
+	ErrInvalidPostStatus = errors.New("invalid post status")
+)
+
 var ErrPostCacheNotFound = errors.New("post not found in cache")
 
+var ErrObjectNotFound = errors.New("object not found")
+
+// PostCacheAdapter stores encoded entries, PostCacheService handles their
+// serialization.
 type PostCacheAdapter interface {
 	Ping(ctx context.Context) error
-	CachePost(ctx context.Context, id uuid.UUID, content interface{}, ttl time.Duration) (interface{}, error)
-	GetPost(ctx context.Context, id uuid.UUID) (interface{}, error)
+	// CachePost stores entry, reporting whether it replaced one still
+	// cached.
+	CachePost(ctx context.Context, key PostCacheKey, entry []byte, ttl time.Duration) (replaced bool, err error)
+	GetPost(ctx context.Context, key PostCacheKey) ([]byte, error)
+	// DeletePost deletes every entry of a post, whatever their variant.
+	DeletePost(ctx context.Context, id uuid.UUID) error
+}
+
+// ErrInvalidCacheNamespace is returned for namespace names holding key
+// pattern characters.
+var ErrInvalidCacheNamespace = errors.New("invalid cache namespace")
+
+type CacheNamespace struct {
+	Name string
+	Keys int
+}
+
+// PostCacheNamespaceAdapter manages the namespaces of the shared cache, one
+// per RenderNamespace ever deployed.
+type PostCacheNamespaceAdapter interface {
+	Namespace() string
+	Namespaces(ctx context.Context) ([]CacheNamespace, error)
+	// PurgeNamespace deletes every entry of namespace and returns how many
+	// were deleted.
+	PurgeNamespace(ctx context.Context, namespace string) (int, error)
 }
 
 type PostStorageAdapter interface {
 	Write(ctx context.Context, object *ObjectPath, content io.Reader) error
+	// Get returns ErrObjectNotFound when object does not exist.
 	Get(ctx context.Context, object *ObjectPath) (io.ReadCloser, error)
+	Exists(ctx context.Context, object *ObjectPath) (bool, error)
+	Delete(ctx context.Context, object *ObjectPath) error
+}
+
+type PostVoteBufferAdapter interface {
+	BufferVote(ctx context.Context, vote Vote) error
+	// PendingScores counts the votes of the claimed batch until it is
+	// acked.
+	PendingScores(ctx context.Context) (map[uuid.UUID]int, error)
+	// ClaimVoteBatch hands back the last claimed batch until it is acked,
+	// up to maxAttempts times, before setting it aside as dead letters.
+	ClaimVoteBatch(ctx context.Context, maxAttempts int) (VoteBatch, error)
+	AckVoteBatch(ctx context.Context, batchID string) error
+	// DeadVotes counts the votes set aside as dead letters.
+	DeadVotes(ctx context.Context) (int64, error)
+	// ReplayDeadVotes buffers the dead letters again and returns how many
+	// votes were replayed.
+	ReplayDeadVotes(ctx context.Context) (int64, error)
 }
 
 type PostCacheService struct {
@@ -81,28 +240,70 @@ func (pcs *PostCacheService) Ping(ctx context.Context) error {
 	return pcs.adapter.Ping(ctx)
 }
 
-func (pcs *PostCacheService) CachePost(ctx context.Context, id uuid.UUID, content string, ttl time.Duration) (string, error) {
-	ret, err := pcs.adapter.CachePost(ctx, id, content, ttl)
+func (pcs *PostCacheService) CacheHTML(ctx context.Context, id uuid.UUID, variant string, html string, ttl time.Duration) error {
+	key := PostCacheKey{ID: id, Kind: PostCacheEntryHTML, Variant: variant}
+	_, err := pcs.adapter.CachePost(ctx, key, encodePostCacheText(key.Kind, cacheExpiry(ttl), html), ttl)
+	return err
+}
+
+// cacheExpiry is the expiry stored in an entry cached for ttl, zero for
+// the entries which never expire.
+func cacheExpiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// GetHTML returns a cached rendering with its expiry, zero when it never
+// expires.
+func (pcs *PostCacheService) GetHTML(ctx context.Context, id uuid.UUID, variant string) (string, time.Time, error) {
+	key := PostCacheKey{ID: id, Kind: PostCacheEntryHTML, Variant: variant}
+	entry, err := pcs.adapter.GetPost(ctx, key)
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
-	content, ok := ret.(string)
-	if !ok {
-		return "", ErrPostCacheNotFound
+	html, expiresAt, err := decodePostCacheText(key.Kind, entry)
+	if err != nil {
+		return "", time.Time{}, pcs.discard(ctx, id, err)
 	}
-	return content, nil
+	return html, expiresAt, nil
 }
 
-func (pcs *PostCacheService) GetPost(ctx context.Context, id uuid.UUID) (string, error) {
-	ret, err := pcs.adapter.GetPost(ctx, id)
+func (pcs *PostCacheService) CacheCanvas(ctx context.Context, id uuid.UUID, variant string, canvas *render.Canvas, ttl time.Duration) error {
+	entry, err := encodePostCacheCanvas(cacheExpiry(ttl), canvas)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("cannot encode post canvas: %w", err)
 	}
-	content, ok := ret.(string)
-	if !ok {
-		return "", ErrPostCacheNotFound
+	_, err = pcs.adapter.CachePost(ctx, PostCacheKey{ID: id, Kind: PostCacheEntryCanvas, Variant: variant}, entry, ttl)
+	return err
+}
+
+// GetCanvas returns a cached rendering with its expiry.
+func (pcs *PostCacheService) GetCanvas(ctx context.Context, id uuid.UUID, variant string) (*render.Canvas, time.Time, error) {
+	entry, err := pcs.adapter.GetPost(ctx, PostCacheKey{ID: id, Kind: PostCacheEntryCanvas, Variant: variant})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	canvas, expiresAt, err := decodePostCacheCanvas(entry)
+	if err != nil {
+		return nil, time.Time{}, pcs.discard(ctx, id, err)
+	}
+	return canvas, expiresAt, nil
+}
+
+// discard deletes the entries of a post after one failed to decode, so that
+// it is rendered again, and reports a miss.
+func (pcs *PostCacheService) discard(ctx context.Context, id uuid.UUID, decodeErr error) error {
+	log.Printf("[WARNING] discarding cached post %s: %s\n", id, decodeErr)
+	if err := pcs.adapter.DeletePost(ctx, id); err != nil {
+		log.Printf("[WARNING] failed to delete cached post %s: %s\n", id, err)
 	}
-	return content, nil
+	return ErrPostCacheNotFound
+}
+
+func (pcs *PostCacheService) DeletePost(ctx context.Context, id uuid.UUID) error {
+	return pcs.adapter.DeletePost(ctx, id)
 }
 
 type PostDatabaseService struct {
@@ -131,22 +332,96 @@ func (pds *PostDatabaseService) ListPosts(ctx context.Context) ([]Post, error) {
 	return posts, nil
 }
 
-func (pds *PostDatabaseService) UpvotePost(ctx context.Context, id uuid.UUID) error {
-	err := pds.adapter.UpvotePost(ctx, id)
+func (pds *PostDatabaseService) GetPost(ctx context.Context, id uuid.UUID) (Post, error) {
+	post, err := pds.adapter.GetPost(ctx, id)
+	if err == sql.ErrNoRows {
+		return Post{}, ErrPostNotFound
+	}
+	if err != nil {
+		return Post{}, fmt.Errorf("cannot get post: %w", err)
+	}
+	return post, nil
+}
+
+func (pds *PostDatabaseService) ListPostsByStatus(ctx context.Context, status PostStatus) ([]Post, error) {
+	posts, err := pds.adapter.ListPostsByStatus(ctx, status)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list %s posts: %w", status, err)
+	}
+	return posts, nil
+}
+
+// DeletePost soft deletes a post, it can be brought back with RestorePost.
+func (pds *PostDatabaseService) DeletePost(ctx context.Context, args ModeratePostParams) error {
+	err := pds.adapter.DeletePost(ctx, args)
+	if err != nil {
+		return fmt.Errorf("cannot delete post: %w", err)
+	}
+	return nil
+}
+
+func (pds *PostDatabaseService) RestorePost(ctx context.Context, args ModeratePostParams) error {
+	err := pds.adapter.RestorePost(ctx, args)
+	if err != nil {
+		return fmt.Errorf("cannot restore post: %w", err)
+	}
+	return nil
+}
+
+func (pds *PostDatabaseService) SetPostStatus(ctx context.Context, args SetPostStatusParams) error {
+	if !args.Status.Moderable() {
+		return fmt.Errorf("cannot set post status to %q: %w", args.Status, ErrInvalidPostStatus)
+	}
+	err := pds.adapter.SetPostStatus(ctx, args)
+	if err != nil {
+		return fmt.Errorf("cannot set post status: %w", err)
+	}
+	return nil
+}
+
+func (pds *PostDatabaseService) UpvotePost(ctx context.Context, args VotePostParams) error {
+	err := pds.adapter.UpvotePost(ctx, args)
 	if err != nil {
 		return fmt.Errorf("cannot upvote post: %w", err)
 	}
 	return nil
 }
 
-func (pds *PostDatabaseService) DownvotePost(ctx context.Context, id uuid.UUID) error {
-	err := pds.adapter.DownvotePost(ctx, id)
+func (pds *PostDatabaseService) DownvotePost(ctx context.Context, args VotePostParams) error {
+	err := pds.adapter.DownvotePost(ctx, args)
 	if err != nil {
 		return fmt.Errorf("cannot downvote post: %w", err)
 	}
 	return nil
 }
 
+func (pds *PostDatabaseService) ScoreHistory(ctx context.Context, id uuid.UUID) ([]ScorePoint, error) {
+	points, err := pds.adapter.ListScoreHistory(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list post score history: %w", err)
+	}
+	return points, nil
+}
+
+// RecomputeScores rebuilds every post score from the vote event log.
+func (pds *PostDatabaseService) RecomputeScores(ctx context.Context) error {
+	err := pds.adapter.RecomputeScores(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot recompute scores: %w", err)
+	}
+	return nil
+}
+
+// DeleteVoterVotes removes every vote cast by voter and withdraws them
+// from the affected post scores.
+func (pds *PostDatabaseService) DeleteVoterVotes(ctx context.Context, voter string) error {
+	err := pds.adapter.DeleteVoterVotes(ctx, voter)
+	if err != nil {
+		return fmt.Errorf("cannot delete voter votes: %w", err)
+	}
+	return nil
+}
+
 func (pds *PostDatabaseService) CreatePost(ctx context.Context, args CreatePostParams) error {
 	_, err := pds.adapter.CreatePost(ctx, args)
 	if err != nil {
@@ -155,6 +430,58 @@ func (pds *PostDatabaseService) CreatePost(ctx context.Context, args CreatePostP
 	return nil
 }
 
+func (pds *PostDatabaseService) ApplyVoteBatch(ctx context.Context, batch VoteBatch) error {
+	err := pds.adapter.ApplyVoteBatch(ctx, batch)
+	if err != nil {
+		return fmt.Errorf("cannot apply vote batch %s: %w", batch.ID, err)
+	}
+	return nil
+}
+
+func (pds *PostDatabaseService) PruneVoteBatches(ctx context.Context, before time.Time) (int64, error) {
+	n, err := pds.adapter.PruneVoteBatches(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("cannot prune vote batches: %w", err)
+	}
+	return n, nil
+}
+
+// CreatePendingPost registers a post that stays hidden until PublishPost is
+// called, along with the outbox record used to reconcile it.
+func (pds *PostDatabaseService) CreatePendingPost(ctx context.Context, args CreatePostParams) error {
+	err := pds.adapter.CreatePendingPost(ctx, args)
+	if err != nil {
+		return fmt.Errorf("cannot create pending post: %w", err)
+	}
+	return nil
+}
+
+// PublishPost returns ErrPostNotFound when the post is no longer pending,
+// published already or discarded by the PostOutboxWorker.
+func (pds *PostDatabaseService) PublishPost(ctx context.Context, id uuid.UUID) error {
+	err := pds.adapter.PublishPost(ctx, id)
+	if err != nil {
+		return fmt.Errorf("cannot publish post: %w", err)
+	}
+	return nil
+}
+
+func (pds *PostDatabaseService) DiscardPost(ctx context.Context, id uuid.UUID) error {
+	err := pds.adapter.DiscardPost(ctx, id)
+	if err != nil {
+		return fmt.Errorf("cannot discard post: %w", err)
+	}
+	return nil
+}
+
+func (pds *PostDatabaseService) ListOutbox(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	entries, err := pds.adapter.ListOutbox(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list post outbox: %w", err)
+	}
+	return entries, nil
+}
+
 type PostStorageService struct {
 	adapter PostStorageAdapter
 }
@@ -172,3 +499,11 @@ func (pss *PostStorageService) Write(ctx context.Context, object *ObjectPath, r
 func (pss *PostStorageService) Get(ctx context.Context, object *ObjectPath) (io.ReadCloser, error) {
 	return pss.adapter.Get(ctx, object)
 }
+
+func (pss *PostStorageService) Exists(ctx context.Context, object *ObjectPath) (bool, error) {
+	return pss.adapter.Exists(ctx, object)
+}
+
+func (pss *PostStorageService) Delete(ctx context.Context, object *ObjectPath) error {
+	return pss.adapter.Delete(ctx, object)
+}
@@ -0,0 +1,26 @@
+package web_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/web"
+	"github.com/skale-5/skalogram/web/pkg/memory"
+)
+
+func TestPostCacheServiceDiscardsUndecodableEntries(t *testing.T) {
+	ctx := context.Background()
+	cache := memory.NewClient(10, 0)
+	pcs := web.NewPostCacheService(cache)
+	id := uuid.New()
+	key := web.PostCacheKey{ID: id, Kind: web.PostCacheEntryHTML}
+	cache.CachePost(ctx, key, []byte("garbage"), 0)
+
+	if _, _, err := pcs.GetHTML(ctx, id, ""); err != web.ErrPostCacheNotFound {
+		t.Errorf("got %v, want ErrPostCacheNotFound", err)
+	}
+	if _, err := cache.GetPost(ctx, key); err != web.ErrPostCacheNotFound {
+		t.Errorf("got %v, want the undecodable entry deleted", err)
+	}
+}
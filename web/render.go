@@ -0,0 +1,572 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/skale-5/skalogram/render"
+	"golang.org/x/sync/singleflight"
+)
+
+// PostLockAdapter provides locks shared by every instance.
+type PostLockAdapter interface {
+	AcquireLock(ctx context.Context, key string, ttl time.Duration) (token string, acquired bool, err error)
+	ReleaseLock(ctx context.Context, key string, token string) error
+}
+
+const (
+	lockPollInterval   = 50 * time.Millisecond
+	defaultRenderCost  = 100 * time.Millisecond
+	renderCostSmoothed = 0.2
+)
+
+// PostRenderService renders posts to ascii through the cache. Concurrent
+// renders of a post are collapsed into a single one per instance, and a
+// shared lock elects a single instance to render it. Entries are refreshed
+// ahead of their expiry with a probability growing as the expiry nears.
+// Pages do not wait for renders, missing entries are queued for the
+// PostRenderWorker.
+type PostRenderService struct {
+	postCacheService   *PostCacheService
+	postStorageService *PostStorageService
+	lock               PostLockAdapter
+	queue              RenderQueueAdapter
+	queuePendingTTL    time.Duration
+	queueMaxLength     int
+	failures           *RenderFailureService
+	cacheTTL           time.Duration
+	lockTTL            time.Duration
+	lockWait           time.Duration
+	earlyRefreshBeta   float64
+	defaults           render.Options
+	presets            map[string]render.Options
+	namespace          string
+
+	group singleflight.Group
+	// renderCost is a moving average of the render duration, in nanoseconds
+	renderCost int64
+}
+
+type NewPostRenderServiceArgs struct {
+	PostCacheService   *PostCacheService
+	PostStorageService *PostStorageService
+	// Lock is optional, without it instances do not coordinate renders.
+	Lock  PostLockAdapter
+	Queue RenderQueueAdapter
+	// QueuePendingTTL is how long a render stays queued once, it should
+	// exceed the render duration.
+	QueuePendingTTL time.Duration
+	// QueueMaxLength bounds the queued jobs, those of the variants of the
+	// default options only filling half of the queue so that the pages
+	// with the default options are still queued when it is saturated.
+	QueueMaxLength int
+	// Failures is optional, without it failing renders are not recorded
+	// and retried on every miss.
+	Failures         *RenderFailureService
+	CacheTTL         time.Duration
+	LockTTL          time.Duration
+	LockWait         time.Duration
+	EarlyRefreshBeta float64
+	// Defaults are the render options of the pages, requests may override
+	// them.
+	Defaults render.Options
+}
+
+// renderJob is a post to render with options, variant keys its cache
+// entries. Uncached jobs are rendered inline on every request.
+type renderJob struct {
+	post     Post
+	opts     render.Options
+	variant  string
+	uncached bool
+	// failed is set when a failure of the post was recorded, to be cleared
+	// once it renders.
+	failed bool
+}
+
+func (j renderJob) key() string {
+	return j.post.ID.String() + ":" + j.variant
+}
+
+func NewPostRenderService(args NewPostRenderServiceArgs) *PostRenderService {
+	return &PostRenderService{
+		postCacheService:   args.PostCacheService,
+		postStorageService: args.PostStorageService,
+		lock:               args.Lock,
+		queue:              args.Queue,
+		queuePendingTTL:    args.QueuePendingTTL,
+		queueMaxLength:     args.QueueMaxLength,
+		failures:           args.Failures,
+		cacheTTL:           args.CacheTTL,
+		lockTTL:            args.LockTTL,
+		lockWait:           args.LockWait,
+		earlyRefreshBeta:   args.EarlyRefreshBeta,
+		defaults:           args.Defaults,
+		presets:            RenderPresets(args.Defaults),
+		namespace:          RenderNamespace(args.Defaults),
+		renderCost:         int64(defaultRenderCost),
+	}
+}
+
+func (prs *PostRenderService) DefaultOptions() render.Options {
+	return prs.defaults
+}
+
+var ErrUnknownRenderPreset = errors.New("unknown render preset")
+
+// RenderPresets are the variants of the default options pages can be
+// rendered with, requests may override them further.
+func RenderPresets(defaults render.Options) map[string]render.Options {
+	halfBlock, braille, mono, wide, edges := defaults, defaults, defaults, defaults, defaults
+	halfBlock.Mode = render.ModeHalfBlock
+	braille.Mode = render.ModeBraille
+	mono.Color = false
+	wide.Width, wide.Height = 2*defaults.Width, 2*defaults.Height
+	if wide.Width > render.MaxWidth {
+		wide.Width = render.MaxWidth
+	}
+	if wide.Height > render.MaxHeight {
+		wide.Height = render.MaxHeight
+	}
+	edges.Pipeline = "edges"
+	return map[string]render.Options{
+		"halfblock": halfBlock,
+		"braille":   braille,
+		"mono":      mono,
+		"wide":      wide,
+		"edges":     edges,
+	}
+}
+
+// Preset returns the options of a preset, the default ones for an empty
+// name.
+func (prs *PostRenderService) Preset(name string) (render.Options, error) {
+	if name == "" {
+		return prs.defaults, nil
+	}
+	opts, ok := prs.presets[name]
+	if !ok {
+		return render.Options{}, fmt.Errorf("%w %q", ErrUnknownRenderPreset, name)
+	}
+	return opts, nil
+}
+
+func (prs *PostRenderService) job(post Post, opts render.Options) renderJob {
+	job := renderJob{post: post, opts: opts}
+	if opts != prs.defaults {
+		job.variant = opts.Hash()
+		job.uncached = !prs.cacheable(opts)
+	}
+	return job
+}
+
+// cacheable reports whether the renderings with opts are cached. Those with
+// the ramp or pipeline of neither the defaults nor a preset are not, as
+// these free form overrides would not bound the cache entries of a post.
+func (prs *PostRenderService) cacheable(opts render.Options) bool {
+	if opts.Ramp != prs.defaults.Ramp {
+		return false
+	}
+	if opts.Pipeline == prs.defaults.Pipeline {
+		return true
+	}
+	for _, preset := range prs.presets {
+		if opts.Pipeline == preset.Pipeline {
+			return true
+		}
+	}
+	return false
+}
+
+// Ascii returns the rendered ascii of a post as HTML from cache, or queues
+// its render and returns ErrRenderPending. Posts failing to render return
+// a RenderError.
+func (prs *PostRenderService) Ascii(ctx context.Context, post Post, opts render.Options) (string, error) {
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+	job := prs.job(post, opts)
+	if job.uncached {
+		canvas, err := prs.uncachedCanvas(ctx, job)
+		if err != nil {
+			return "", err
+		}
+		return canvas.HTML(), nil
+	}
+	cachedAscii, expiresAt, err := prs.postCacheService.GetHTML(ctx, post.ID, job.variant)
+	if err != nil && err != ErrPostCacheNotFound {
+		log.Printf("[WARNING] failed to retreive ascii in cache: %s\n", err)
+	}
+	if len(cachedAscii) > 0 {
+		if prs.shouldRefreshEarly(expiresAt) {
+			prs.refresh(ctx, job)
+		}
+		return cachedAscii, nil
+	}
+
+	if err := prs.recordedFailure(ctx, &job); err != nil {
+		return "", err
+	}
+	if err := prs.enqueue(ctx, job); err != nil {
+		if !errors.Is(err, ErrCircuitOpen) {
+			log.Printf("[WARNING] %s, rendering it inline\n", err)
+		}
+		canvas, err := prs.canvas(ctx, job)
+		if err != nil {
+			return "", err
+		}
+		return canvas.HTML(), nil
+	}
+	return "", ErrRenderPending
+}
+
+// Canvas returns the rendering of a post, from cache when possible.
+func (prs *PostRenderService) Canvas(ctx context.Context, post Post, opts render.Options) (*render.Canvas, error) {
+	return prs.cachedCanvas(ctx, post, opts, false)
+}
+
+// CanvasAsync is Canvas, but on a cache miss the render is queued and
+// ErrRenderPending returned.
+func (prs *PostRenderService) CanvasAsync(ctx context.Context, post Post, opts render.Options) (*render.Canvas, error) {
+	return prs.cachedCanvas(ctx, post, opts, true)
+}
+
+func (prs *PostRenderService) cachedCanvas(ctx context.Context, post Post, opts render.Options, async bool) (*render.Canvas, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	job := prs.job(post, opts)
+	if job.uncached {
+		return prs.uncachedCanvas(ctx, job)
+	}
+	canvas, expiresAt, err := prs.postCacheService.GetCanvas(ctx, post.ID, job.variant)
+	if err != nil && err != ErrPostCacheNotFound {
+		log.Printf("[WARNING] failed to retreive canvas in cache: %s\n", err)
+	}
+	if err == nil {
+		if prs.shouldRefreshEarly(expiresAt) {
+			prs.refresh(ctx, job)
+		}
+		return canvas, nil
+	}
+	if err := prs.recordedFailure(ctx, &job); err != nil {
+		return nil, err
+	}
+	if async {
+		err := prs.enqueue(ctx, job)
+		if err == nil {
+			return nil, ErrRenderPending
+		}
+		if !errors.Is(err, ErrCircuitOpen) {
+			log.Printf("[WARNING] %s, rendering it inline\n", err)
+		}
+	}
+	return prs.canvas(ctx, job)
+}
+
+// uncachedCanvas renders the post inline, for the uncached jobs.
+func (prs *PostRenderService) uncachedCanvas(ctx context.Context, job renderJob) (*render.Canvas, error) {
+	if err := prs.recordedFailure(ctx, &job); err != nil {
+		return nil, err
+	}
+	return prs.canvas(ctx, job)
+}
+
+// recordedFailure returns the recorded failure of a post when it is
+// permanent, whatever the variant, the others are retried. It flags the job
+// when a failure may be recorded.
+func (prs *PostRenderService) recordedFailure(ctx context.Context, job *renderJob) error {
+	if prs.failures == nil {
+		return nil
+	}
+	failure, err := prs.failures.Get(ctx, job.post.ID)
+	if err == ErrRenderFailureNotFound {
+		return nil
+	}
+	job.failed = true
+	if err != nil {
+		log.Printf("[WARNING] failed to retreive render failure of post %s: %s\n", job.post.ID, err)
+		return nil
+	}
+	if failure.Category.Permanent() {
+		return failure.Err()
+	}
+	return nil
+}
+
+// Retry forgets the failures of a post once repaired, and queues its render
+// with the default options.
+func (prs *PostRenderService) Retry(ctx context.Context, post Post) error {
+	if prs.failures != nil {
+		if err := prs.failures.Clear(ctx, post.ID); err != nil {
+			return fmt.Errorf("cannot clear render failure of post %s: %w", post.ID, err)
+		}
+	}
+	return prs.Enqueue(ctx, post, prs.defaults)
+}
+
+// Enqueue queues the render of a post for the workers, unless it is already
+// queued.
+func (prs *PostRenderService) Enqueue(ctx context.Context, post Post, opts render.Options) error {
+	return prs.enqueue(ctx, prs.job(post, opts))
+}
+
+func (prs *PostRenderService) enqueue(ctx context.Context, job renderJob) error {
+	maxLength := prs.queueMaxLength
+	if job.variant != "" {
+		maxLength /= 2
+	}
+	queued, err := prs.queue.EnqueueRender(ctx, RenderJob{
+		PostID:  job.post.ID,
+		ImgUrl:  job.post.ImgUrl,
+		Options: job.opts,
+		// only the moderation pages show the posts not published
+		Moderation: job.post.Status != PostStatusPublished,
+		Failed:     job.failed,
+	}, prs.queuePendingTTL, maxLength)
+	if errors.Is(err, ErrRenderQueueFull) {
+		renderQueueMetrics.Add("rejected_full", 1)
+		return fmt.Errorf("cannot enqueue render of post %s: %w", job.post.ID, err)
+	}
+	if err != nil {
+		renderQueueMetrics.Add("enqueue_failed", 1)
+		return fmt.Errorf("cannot enqueue render of post %s: %w", job.post.ID, err)
+	}
+	if queued {
+		renderQueueMetrics.Add("enqueued", 1)
+	} else {
+		renderQueueMetrics.Add("deduplicated", 1)
+	}
+	return nil
+}
+
+// canvas renders a post inline, concurrent callers sharing a render which
+// is not canceled with the request of the first one.
+func (prs *PostRenderService) canvas(ctx context.Context, job renderJob) (*render.Canvas, error) {
+	result := prs.group.DoChan(job.key(), func() (interface{}, error) {
+		renderCtx, cancel := context.WithTimeout(detachedContext{ctx}, renderJobTimeout)
+		defer cancel()
+		return prs.renderShared(renderCtx, job, true)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*render.Canvas), nil
+	}
+}
+
+// detachedContext keeps the values of its parent but not its cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// shouldRefreshEarly implements the probabilistic early expiration: the
+// entry is refreshed when renderCost * beta * -ln(rand) exceeds its
+// remaining TTL.
+func (prs *PostRenderService) shouldRefreshEarly(expiresAt time.Time) bool {
+	if prs.earlyRefreshBeta <= 0 {
+		return false
+	}
+	remaining := time.Until(expiresAt)
+	if remaining <= 0 {
+		return false
+	}
+	gap := float64(atomic.LoadInt64(&prs.renderCost)) * prs.earlyRefreshBeta * -math.Log(1-rand.Float64())
+	return gap >= float64(remaining)
+}
+
+func (prs *PostRenderService) refresh(ctx context.Context, job renderJob) {
+	if err := prs.enqueue(ctx, job); err != nil {
+		log.Printf("[WARNING] failed to refresh post %s ascii: %s\n", job.post.ID, err)
+	}
+}
+
+// Process renders a queued post and caches it, unless another instance is
+// already rendering it.
+func (prs *PostRenderService) Process(ctx context.Context, job RenderJob) error {
+	post := Post{ID: job.PostID, ImgUrl: job.ImgUrl}
+	renderJob := prs.job(post, job.Options)
+	renderJob.failed = job.Failed
+	return prs.refreshJob(ctx, renderJob)
+}
+
+func (prs *PostRenderService) refreshJob(ctx context.Context, job renderJob) error {
+	_, err, _ := prs.group.Do("refresh:"+job.key(), func() (interface{}, error) {
+		return prs.renderShared(ctx, job, false)
+	})
+	return err
+}
+
+// renderShared renders the post while holding the shared lock. When another
+// instance holds it, wait for its result when wait is set, otherwise give
+// up. Rendering goes on without the lock if it cannot be obtained in time.
+func (prs *PostRenderService) renderShared(ctx context.Context, job renderJob, wait bool) (*render.Canvas, error) {
+	post := job.post
+	if prs.lock == nil || job.uncached {
+		return prs.render(ctx, job)
+	}
+
+	key := "render:" + prs.namespace + ":" + job.key()
+	token, acquired, err := prs.lock.AcquireLock(ctx, key, prs.lockTTL)
+	if err != nil {
+		if !errors.Is(err, ErrCircuitOpen) {
+			log.Printf("[WARNING] failed to acquire render lock of post %s: %s\n", post.ID, err)
+		}
+		return prs.render(ctx, job)
+	}
+	if acquired {
+		defer func() {
+			if err := prs.lock.ReleaseLock(context.Background(), key, token); err != nil {
+				log.Printf("[WARNING] failed to release render lock of post %s: %s\n", post.ID, err)
+			}
+		}()
+		if wait {
+			// the previous lock holder may have just cached it
+			canvas, _, err := prs.postCacheService.GetCanvas(ctx, post.ID, job.variant)
+			if err == nil {
+				return canvas, nil
+			}
+		}
+		return prs.render(ctx, job)
+	}
+	if !wait {
+		return nil, nil
+	}
+
+	deadline := time.NewTimer(prs.lockWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return prs.render(ctx, job)
+		case <-ticker.C:
+			canvas, _, err := prs.postCacheService.GetCanvas(ctx, post.ID, job.variant)
+			if err == nil {
+				return canvas, nil
+			}
+		}
+	}
+}
+
+// render renders and caches a post, recording the failures of the renders
+// with the default options, failures being keyed by post, and clearing them
+// once the post renders again.
+func (prs *PostRenderService) render(ctx context.Context, job renderJob) (*render.Canvas, error) {
+	canvas, err := prs.renderUncached(ctx, job)
+	if prs.failures == nil || job.variant != "" {
+		return canvas, err
+	}
+	if err != nil {
+		// renders aborted with their request are not failures of the post
+		if ctx.Err() != context.Canceled {
+			if err := prs.failures.Record(context.Background(), job.post.ID, err); err != nil {
+				log.Printf("[WARNING] %s\n", err)
+			}
+		}
+		return nil, err
+	}
+	if job.failed {
+		if err := prs.failures.Clear(context.Background(), job.post.ID); err != nil {
+			log.Printf("[WARNING] failed to clear render failure of post %s: %s\n", job.post.ID, err)
+		}
+	}
+	return canvas, nil
+}
+
+func (prs *PostRenderService) renderUncached(ctx context.Context, job renderJob) (*render.Canvas, error) {
+	post := job.post
+	start := time.Now()
+
+	obj, err := NewObjectPath(post.ImgUrl)
+	if err != nil {
+		return nil, &RenderError{
+			Category: RenderErrorMissingObject,
+			Err:      fmt.Errorf("invalid object path: %w", err),
+		}
+	}
+	fileReader, err := prs.postStorageService.Get(ctx, obj)
+	if err != nil {
+		return nil, &RenderError{
+			Category: storageErrorCategory(ctx, err),
+			Err:      fmt.Errorf("failed to get object: %w", err),
+		}
+	}
+	defer fileReader.Close()
+	canvas, err := GenerateAscii(fileReader, job.opts)
+	if err != nil {
+		category := RenderErrorUndecodable
+		if ctx.Err() != nil {
+			category = RenderErrorTimeout
+		}
+		return nil, &RenderError{
+			Category: category,
+			Err:      fmt.Errorf("failed to generate post ascii: %w", err),
+		}
+	}
+
+	duration := time.Since(start)
+	prs.observeRenderCost(duration)
+	if job.uncached {
+		return canvas, nil
+	}
+
+	err = prs.postCacheService.CacheCanvas(ctx, post.ID, job.variant, canvas, prs.cacheTTL)
+	if err != nil {
+		log.Printf("[WARNING] failed to cache canvas: %s\n", err)
+	}
+	err = prs.postCacheService.CacheHTML(ctx, post.ID, job.variant, canvas.HTML(), prs.cacheTTL)
+	if err != nil {
+		log.Printf("[WARNING] failed to cache ascii: %s\n", err)
+	}
+	return canvas, nil
+}
+
+func storageErrorCategory(ctx context.Context, err error) RenderErrorCategory {
+	switch {
+	case errors.Is(err, ErrObjectNotFound):
+		return RenderErrorMissingObject
+	case ctx.Err() != nil:
+		return RenderErrorTimeout
+	}
+	return RenderErrorStorage
+}
+
+func (prs *PostRenderService) observeRenderCost(d time.Duration) {
+	for {
+		old := atomic.LoadInt64(&prs.renderCost)
+		cost := int64(float64(old)*(1-renderCostSmoothed) + float64(d)*renderCostSmoothed)
+		if atomic.CompareAndSwapInt64(&prs.renderCost, old, cost) {
+			return
+		}
+	}
+}
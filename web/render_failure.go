@@ -0,0 +1,137 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RenderErrorCategory tells why a post cannot be rendered.
+type RenderErrorCategory string
+
+const (
+	RenderErrorMissingObject RenderErrorCategory = "missing_object"
+	RenderErrorUndecodable   RenderErrorCategory = "undecodable_image"
+	RenderErrorStorage       RenderErrorCategory = "storage"
+	RenderErrorTimeout       RenderErrorCategory = "timeout"
+	RenderErrorInternal      RenderErrorCategory = "internal"
+)
+
+// Permanent failures are not retried until the post is repaired.
+func (c RenderErrorCategory) Permanent() bool {
+	return c == RenderErrorMissingObject || c == RenderErrorUndecodable
+}
+
+// RenderError is a failed render of a post.
+type RenderError struct {
+	Category RenderErrorCategory
+	Err      error
+}
+
+func (e *RenderError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Category, e.Err)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// RenderErrorCategoryOf categorizes err, a RenderError or not.
+func RenderErrorCategoryOf(err error) RenderErrorCategory {
+	var renderErr *RenderError
+	switch {
+	case errors.As(err, &renderErr):
+		return renderErr.Category
+	case errors.Is(err, context.DeadlineExceeded):
+		return RenderErrorTimeout
+	}
+	return RenderErrorInternal
+}
+
+// RenderFailure records the renders of a post failing since FirstFailedAt.
+type RenderFailure struct {
+	PostID        uuid.UUID
+	Category      RenderErrorCategory
+	Message       string
+	Failures      int
+	FirstFailedAt time.Time
+	LastFailedAt  time.Time
+}
+
+func (f RenderFailure) Err() error {
+	return &RenderError{Category: f.Category, Err: errors.New(f.Message)}
+}
+
+type RecordRenderFailureParams struct {
+	PostID   uuid.UUID
+	Category RenderErrorCategory
+	Message  string
+}
+
+var ErrRenderFailureNotFound = errors.New("render failure not found")
+
+type RenderFailureDatabaseAdapter interface {
+	// RecordRenderFailure creates the failure of a post or counts a new one.
+	RecordRenderFailure(ctx context.Context, arg RecordRenderFailureParams) error
+	GetRenderFailure(ctx context.Context, postID uuid.UUID) (RenderFailure, error)
+	// ListRenderFailures lists the failures of the posts not removed.
+	ListRenderFailures(ctx context.Context) ([]RenderFailure, error)
+	DeleteRenderFailure(ctx context.Context, postID uuid.UUID) error
+}
+
+var renderFailureMetrics = expvar.NewMap("render_failures")
+
+// RenderFailureService keeps track of the posts failing to render, so that
+// they can be repaired.
+type RenderFailureService struct {
+	adapter RenderFailureDatabaseAdapter
+}
+
+func NewRenderFailureService(a RenderFailureDatabaseAdapter) *RenderFailureService {
+	return &RenderFailureService{
+		adapter: a,
+	}
+}
+
+func (rfs *RenderFailureService) Record(ctx context.Context, postID uuid.UUID, renderErr error) error {
+	category := RenderErrorCategoryOf(renderErr)
+	renderFailureMetrics.Add(string(category), 1)
+	var categorized *RenderError
+	if errors.As(renderErr, &categorized) {
+		renderErr = categorized.Err
+	}
+	err := rfs.adapter.RecordRenderFailure(ctx, RecordRenderFailureParams{
+		PostID:   postID,
+		Category: category,
+		Message:  renderErr.Error(),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot record render failure of post %s: %w", postID, err)
+	}
+	return nil
+}
+
+func (rfs *RenderFailureService) Get(ctx context.Context, postID uuid.UUID) (RenderFailure, error) {
+	failure, err := rfs.adapter.GetRenderFailure(ctx, postID)
+	if err == sql.ErrNoRows {
+		return RenderFailure{}, ErrRenderFailureNotFound
+	}
+	if err != nil {
+		return RenderFailure{}, fmt.Errorf("cannot get render failure: %w", err)
+	}
+	return failure, nil
+}
+
+func (rfs *RenderFailureService) List(ctx context.Context) ([]RenderFailure, error) {
+	return rfs.adapter.ListRenderFailures(ctx)
+}
+
+// Clear forgets the failures of a post, once rendered or repaired.
+func (rfs *RenderFailureService) Clear(ctx context.Context, postID uuid.UUID) error {
+	return rfs.adapter.DeleteRenderFailure(ctx, postID)
+}
@@ -0,0 +1,161 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/render"
+)
+
+// RenderJob asks the render workers to render a post with options.
+// Moderation jobs render the posts the moderators see, whatever their
+// status. Failed jobs render posts whose failure was recorded.
+type RenderJob struct {
+	PostID     uuid.UUID      `json:"post_id"`
+	ImgUrl     string         `json:"img_url"`
+	Options    render.Options `json:"options"`
+	Moderation bool           `json:"moderation,omitempty"`
+	Failed     bool           `json:"failed,omitempty"`
+}
+
+// Key identifies the jobs rendering the same post with the same options.
+func (j RenderJob) Key() string {
+	return j.PostID.String() + ":" + j.Options.Hash()
+}
+
+// RenderQueueAdapter queues render jobs for the workers of every instance.
+// A job is queued once until it is done or its pending TTL expires, and is
+// rejected with ErrRenderQueueFull when maxLength jobs are queued already.
+type RenderQueueAdapter interface {
+	EnqueueRender(ctx context.Context, job RenderJob, pendingTTL time.Duration, maxLength int) (queued bool, err error)
+	// DequeueRender waits for a job for a bounded time, and returns
+	// ErrRenderQueueEmpty when none was queued meanwhile.
+	DequeueRender(ctx context.Context) (RenderJob, error)
+	DoneRender(ctx context.Context, job RenderJob) error
+}
+
+var (
+	ErrRenderPending    = errors.New("rendering is pending")
+	ErrRenderQueueFull  = errors.New("render queue is full")
+	ErrRenderQueueEmpty = errors.New("render queue is empty")
+)
+
+const (
+	// renderJobTimeout bounds a single render of the workers, or one shared
+	// by concurrent requests.
+	renderJobTimeout     = 30 * time.Second
+	dequeueRetryInterval = 5 * time.Second
+)
+
+var renderQueueMetrics = expvar.NewMap("render_queue")
+
+// PostRenderWorker renders the queued posts into the cache.
+type PostRenderWorker struct {
+	queue               RenderQueueAdapter
+	postRenderService   *PostRenderService
+	postDatabaseService *PostDatabaseService
+	concurrency         int
+}
+
+type NewPostRenderWorkerArgs struct {
+	Queue             RenderQueueAdapter
+	PostRenderService *PostRenderService
+	// PostDatabaseService checks the status of the posts before rendering
+	// them.
+	PostDatabaseService *PostDatabaseService
+	Concurrency         int
+}
+
+func NewPostRenderWorker(args NewPostRenderWorkerArgs) *PostRenderWorker {
+	concurrency := args.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &PostRenderWorker{
+		queue:               args.Queue,
+		postRenderService:   args.PostRenderService,
+		postDatabaseService: args.PostDatabaseService,
+		concurrency:         concurrency,
+	}
+}
+
+// Run renders the queued posts until ctx is done.
+func (w *PostRenderWorker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < w.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *PostRenderWorker) work(ctx context.Context) {
+	for {
+		job, err := w.queue.DequeueRender(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == ErrRenderQueueEmpty {
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, ErrCircuitOpen) {
+				log.Printf("[WARNING] failed to dequeue render job: %s\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(dequeueRetryInterval):
+			}
+			continue
+		}
+		w.process(ctx, job)
+	}
+}
+
+func (w *PostRenderWorker) process(ctx context.Context, job RenderJob) {
+	defer func() {
+		if err := w.queue.DoneRender(context.Background(), job); err != nil {
+			log.Printf("[WARNING] failed to mark render of post %s done: %s\n", job.PostID, err)
+		}
+	}()
+	if !w.renderable(ctx, job) {
+		renderQueueMetrics.Add("dropped", 1)
+		return
+	}
+
+	start := time.Now()
+	renderCtx, cancel := context.WithTimeout(ctx, renderJobTimeout)
+	err := w.postRenderService.Process(renderCtx, job)
+	cancel()
+	if err != nil {
+		renderQueueMetrics.Add("failed", 1)
+		log.Printf("[WARNING] failed to render post %s: %s\n", job.PostID, err)
+	} else {
+		renderQueueMetrics.Add("rendered", 1)
+		renderQueueMetrics.Add("render_ms", time.Since(start).Milliseconds())
+	}
+}
+
+// renderable reports whether the post of a job is still to be rendered, the
+// posts deleted, hidden or removed since it was queued are not, unless it
+// is a moderation job. Posts whose status cannot be checked are rendered.
+func (w *PostRenderWorker) renderable(ctx context.Context, job RenderJob) bool {
+	post, err := w.postDatabaseService.GetPost(ctx, job.PostID)
+	if errors.Is(err, ErrPostNotFound) {
+		return false
+	}
+	if err != nil {
+		log.Printf("[WARNING] failed to check status of post %s before rendering it: %s\n", job.PostID, err)
+		return true
+	}
+	return job.Moderation || post.Status == PostStatusPublished
+}
@@ -0,0 +1,57 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type workerPosts struct {
+	PostDatabaseAdapter
+	posts map[uuid.UUID]Post
+	err   error
+}
+
+func (db *workerPosts) GetPost(ctx context.Context, id uuid.UUID) (Post, error) {
+	if db.err != nil {
+		return Post{}, db.err
+	}
+	post, ok := db.posts[id]
+	if !ok {
+		return Post{}, ErrPostNotFound
+	}
+	return post, nil
+}
+
+func TestPostRenderWorkerRenderable(t *testing.T) {
+	ctx := context.Background()
+	db := &workerPosts{posts: make(map[uuid.UUID]Post)}
+	w := NewPostRenderWorker(NewPostRenderWorkerArgs{PostDatabaseService: NewPostDatabaseService(db)})
+	published, hidden, deleted := uuid.New(), uuid.New(), uuid.New()
+	db.posts[published] = Post{ID: published, Status: PostStatusPublished}
+	db.posts[hidden] = Post{ID: hidden, Status: PostStatusHidden}
+
+	tests := []struct {
+		name       string
+		job        RenderJob
+		renderable bool
+	}{
+		{"published", RenderJob{PostID: published}, true},
+		{"hidden since queued", RenderJob{PostID: hidden}, false},
+		{"deleted since queued", RenderJob{PostID: deleted}, false},
+		{"hidden for the moderators", RenderJob{PostID: hidden, Moderation: true}, true},
+		{"deleted for the moderators", RenderJob{PostID: deleted, Moderation: true}, false},
+	}
+	for _, tt := range tests {
+		if got := w.renderable(ctx, tt.job); got != tt.renderable {
+			t.Errorf("%s: got renderable %t, want %t", tt.name, got, tt.renderable)
+		}
+	}
+
+	db.err = errors.New("connection refused")
+	if !w.renderable(ctx, RenderJob{PostID: hidden}) {
+		t.Error("got a job dropped on a database error, want it rendered")
+	}
+}
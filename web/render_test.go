@@ -0,0 +1,119 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/render"
+)
+
+func TestRenderPresets(t *testing.T) {
+	defaults := render.DefaultOptions()
+	for name, opts := range RenderPresets(defaults) {
+		if err := opts.Validate(); err != nil {
+			t.Errorf("preset %s: %s", name, err)
+		}
+		if opts == defaults {
+			t.Errorf("preset %s renders like the defaults", name)
+		}
+	}
+
+	defaults.Width, defaults.Height = render.MaxWidth, render.MaxHeight
+	wide := RenderPresets(defaults)["wide"]
+	if err := wide.Validate(); err != nil {
+		t.Errorf("wide preset of the largest defaults: %s", err)
+	}
+}
+
+func TestPostRenderServicePreset(t *testing.T) {
+	defaults := render.DefaultOptions()
+	prs := NewPostRenderService(NewPostRenderServiceArgs{Defaults: defaults})
+	if opts, err := prs.Preset(""); err != nil || opts != defaults {
+		t.Errorf("got %+v, %v, want the defaults", opts, err)
+	}
+	if opts, err := prs.Preset("braille"); err != nil || opts.Mode != render.ModeBraille {
+		t.Errorf("got %+v, %v, want the braille preset", opts, err)
+	}
+	if _, err := prs.Preset("ramp=@"); !errors.Is(err, ErrUnknownRenderPreset) {
+		t.Errorf("got %v, want ErrUnknownRenderPreset", err)
+	}
+}
+
+func TestPostRenderServiceCacheable(t *testing.T) {
+	defaults := render.DefaultOptions()
+	prs := NewPostRenderService(NewPostRenderServiceArgs{Defaults: defaults})
+	tests := []struct {
+		name   string
+		modify func(o *render.Options)
+		cached bool
+	}{
+		{"defaults", func(o *render.Options) {}, true},
+		{"width", func(o *render.Options) { o.Width = 80 }, true},
+		{"mode and color", func(o *render.Options) { o.Mode, o.Color = render.ModeBraille, false }, true},
+		{"edges preset pipeline", func(o *render.Options) { o.Pipeline = "edges" }, true},
+		{"ramp", func(o *render.Options) { o.Ramp = " @" }, false},
+		{"pipeline", func(o *render.Options) { o.Pipeline = "gamma:2" }, false},
+	}
+	for _, tt := range tests {
+		opts := defaults
+		tt.modify(&opts)
+		if job := prs.job(Post{}, opts); job.uncached == tt.cached {
+			t.Errorf("%s: got uncached %t, want %t", tt.name, job.uncached, !tt.cached)
+		}
+	}
+}
+
+type renderFailures struct {
+	RenderFailureDatabaseAdapter
+	failures map[uuid.UUID]RenderFailure
+}
+
+func (db *renderFailures) GetRenderFailure(ctx context.Context, postID uuid.UUID) (RenderFailure, error) {
+	failure, ok := db.failures[postID]
+	if !ok {
+		return RenderFailure{}, sql.ErrNoRows
+	}
+	return failure, nil
+}
+
+type renderJobs struct {
+	RenderQueueAdapter
+	jobs []RenderJob
+}
+
+func (q *renderJobs) EnqueueRender(ctx context.Context, job RenderJob, pendingTTL time.Duration, maxLength int) (bool, error) {
+	q.jobs = append(q.jobs, job)
+	return true, nil
+}
+
+func TestPostRenderServiceFailedJob(t *testing.T) {
+	ctx := context.Background()
+	rendered, timedOut := uuid.New(), uuid.New()
+	queue := &renderJobs{}
+	prs := NewPostRenderService(NewPostRenderServiceArgs{
+		Defaults: render.DefaultOptions(),
+		Queue:    queue,
+		Failures: NewRenderFailureService(&renderFailures{failures: map[uuid.UUID]RenderFailure{
+			timedOut: {PostID: timedOut, Category: RenderErrorTimeout},
+		}}),
+	})
+	for _, id := range []uuid.UUID{rendered, timedOut} {
+		job := prs.job(Post{ID: id}, prs.DefaultOptions())
+		if err := prs.recordedFailure(ctx, &job); err != nil {
+			t.Fatalf("post %s: %s", id, err)
+		}
+		if job.failed != (id == timedOut) {
+			t.Errorf("post %s: got failed %t", id, job.failed)
+		}
+		if err := prs.enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(queue.jobs) != 2 || queue.jobs[0].Failed || !queue.jobs[1].Failed {
+		t.Errorf("got jobs %+v, want only the second failed", queue.jobs)
+	}
+}
@@ -0,0 +1,234 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ReportReason string
+
+const (
+	ReportReasonOffensive ReportReason = "offensive"
+	ReportReasonSpam      ReportReason = "spam"
+	ReportReasonNudity    ReportReason = "nudity"
+	ReportReasonViolence  ReportReason = "violence"
+	ReportReasonCopyright ReportReason = "copyright"
+	ReportReasonOther     ReportReason = "other"
+)
+
+var ReportReasons = []ReportReason{
+	ReportReasonOffensive,
+	ReportReasonSpam,
+	ReportReasonNudity,
+	ReportReasonViolence,
+	ReportReasonCopyright,
+	ReportReasonOther,
+}
+
+func (rr ReportReason) Valid() bool {
+	for _, reason := range ReportReasons {
+		if rr == reason {
+			return true
+		}
+	}
+	return false
+}
+
+type ReportResolution string
+
+const (
+	ReportResolutionDismissed ReportResolution = "dismissed"
+	ReportResolutionRemoved   ReportResolution = "removed"
+)
+
+// ReportSystemModerator is the moderator ID recorded when a post is hidden
+// automatically.
+const ReportSystemModerator = "system"
+
+var (
+	ErrInvalidReportReason = errors.New("invalid report reason")
+	ErrReportRateLimited   = errors.New("too many reports")
+)
+
+// reportRateWindow is the period over which the reports of an IP address
+// are limited.
+const reportRateWindow = time.Hour
+
+type Report struct {
+	ID         int64
+	PostID     uuid.UUID
+	Reporter   string
+	ReporterIP string
+	Reason     ReportReason
+	Details    string
+	CreatedAt  time.Time
+}
+
+// CreateReportParams identifies the reporter by its visitor cookie, which
+// can be renewed at will, and by its IP address.
+type CreateReportParams struct {
+	PostID     uuid.UUID
+	Reporter   string
+	ReporterIP string
+	Reason     ReportReason
+	Details    string
+}
+
+type ResolveReportsParams struct {
+	PostID      uuid.UUID
+	Resolution  ReportResolution
+	ModeratorID string
+}
+
+// ReportedPost is an entry of the moderation queue.
+type ReportedPost struct {
+	Post    Post
+	Reports []Report
+}
+
+type ReportDatabaseAdapter interface {
+	// CreateReport records a report and returns the number of distinct
+	// reporter IP addresses with an open report on the post.
+	CreateReport(ctx context.Context, arg CreateReportParams) (int, error)
+	// CountReportsSince counts the reports sent from an IP address since a
+	// date.
+	CountReportsSince(ctx context.Context, reporterIP string, since time.Time) (int, error)
+	ListOpenReports(ctx context.Context) ([]Report, error)
+	ResolveReports(ctx context.Context, arg ResolveReportsParams) error
+	// RemoveReportedPost deletes a post and resolves its open reports at
+	// once.
+	RemoveReportedPost(ctx context.Context, arg ModeratePostParams) error
+}
+
+type ReportService struct {
+	adapter             ReportDatabaseAdapter
+	postDatabaseService *PostDatabaseService
+	hideThreshold       int
+	rateLimit           int
+}
+
+type NewReportServiceArgs struct {
+	Adapter             ReportDatabaseAdapter
+	PostDatabaseService *PostDatabaseService
+	HideThreshold       int
+	// RateLimit bounds the reports of an IP address per hour, 0 disables
+	// it.
+	RateLimit int
+}
+
+func NewReportService(args NewReportServiceArgs) *ReportService {
+	return &ReportService{
+		adapter:             args.Adapter,
+		postDatabaseService: args.PostDatabaseService,
+		hideThreshold:       args.HideThreshold,
+		rateLimit:           args.RateLimit,
+	}
+}
+
+// Report flags a published post, hiding it for review once reported from
+// enough distinct IP addresses, visitors being able to renew their cookie.
+func (rs *ReportService) Report(ctx context.Context, args CreateReportParams) error {
+	if !args.Reason.Valid() {
+		return fmt.Errorf("cannot report post with reason %q: %w", args.Reason, ErrInvalidReportReason)
+	}
+	if rs.rateLimit > 0 {
+		sent, err := rs.adapter.CountReportsSince(ctx, args.ReporterIP, time.Now().Add(-reportRateWindow))
+		if err != nil {
+			return fmt.Errorf("cannot count reports: %w", err)
+		}
+		if sent >= rs.rateLimit {
+			return fmt.Errorf("cannot report post from %s: %w", args.ReporterIP, ErrReportRateLimited)
+		}
+	}
+	post, err := rs.postDatabaseService.GetPost(ctx, args.PostID)
+	if err != nil {
+		return err
+	}
+	if post.Status != PostStatusPublished {
+		return ErrPostNotFound
+	}
+	reports, err := rs.adapter.CreateReport(ctx, args)
+	if err != nil {
+		return fmt.Errorf("cannot create report: %w", err)
+	}
+	if rs.hideThreshold <= 0 || reports < rs.hideThreshold {
+		return nil
+	}
+	return rs.postDatabaseService.SetPostStatus(ctx, SetPostStatusParams{
+		ID:          args.PostID,
+		Status:      PostStatusPendingReview,
+		Reason:      fmt.Sprintf("automatically hidden after %d reports", reports),
+		ModeratorID: ReportSystemModerator,
+	})
+}
+
+// Queue lists the posts with open reports, most reported first.
+func (rs *ReportService) Queue(ctx context.Context) ([]ReportedPost, error) {
+	reports, err := rs.adapter.ListOpenReports(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list open reports: %w", err)
+	}
+
+	var queue []ReportedPost
+	index := make(map[uuid.UUID]int)
+	for _, report := range reports {
+		i, ok := index[report.PostID]
+		if !ok {
+			post, err := rs.postDatabaseService.GetPost(ctx, report.PostID)
+			if err != nil {
+				return nil, err
+			}
+			i = len(queue)
+			index[report.PostID] = i
+			queue = append(queue, ReportedPost{Post: post})
+		}
+		queue[i].Reports = append(queue[i].Reports, report)
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		return len(queue[i].Reports) > len(queue[j].Reports)
+	})
+	return queue, nil
+}
+
+// Dismiss closes the open reports of a post and publishes it back if it
+// was hidden by reports.
+func (rs *ReportService) Dismiss(ctx context.Context, postID uuid.UUID, moderatorID string) error {
+	err := rs.adapter.ResolveReports(ctx, ResolveReportsParams{
+		PostID:      postID,
+		Resolution:  ReportResolutionDismissed,
+		ModeratorID: moderatorID,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot dismiss reports: %w", err)
+	}
+	post, err := rs.postDatabaseService.GetPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+	if post.Status != PostStatusPendingReview {
+		return nil
+	}
+	return rs.postDatabaseService.RestorePost(ctx, ModeratePostParams{
+		ID:          postID,
+		Reason:      "reports dismissed",
+		ModeratorID: moderatorID,
+	})
+}
+
+// Remove deletes a reported post and closes its open reports.
+func (rs *ReportService) Remove(ctx context.Context, postID uuid.UUID, moderatorID string) error {
+	err := rs.adapter.RemoveReportedPost(ctx, ModeratePostParams{
+		ID:          postID,
+		Reason:      "removed after reports",
+		ModeratorID: moderatorID,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot remove reported post: %w", err)
+	}
+	return nil
+}
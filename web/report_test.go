@@ -0,0 +1,78 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type reportPosts struct {
+	PostDatabaseAdapter
+	posts map[uuid.UUID]Post
+}
+
+func (db *reportPosts) GetPost(ctx context.Context, id uuid.UUID) (Post, error) {
+	post, ok := db.posts[id]
+	if !ok {
+		return Post{}, ErrPostNotFound
+	}
+	return post, nil
+}
+
+func (db *reportPosts) SetPostStatus(ctx context.Context, arg SetPostStatusParams) error {
+	post := db.posts[arg.ID]
+	post.Status = arg.Status
+	db.posts[arg.ID] = post
+	return nil
+}
+
+type reportDatabase struct {
+	ReportDatabaseAdapter
+	reports map[uuid.UUID]int
+}
+
+func (db *reportDatabase) CountReportsSince(ctx context.Context, reporterIP string, since time.Time) (int, error) {
+	return 0, nil
+}
+
+func (db *reportDatabase) CreateReport(ctx context.Context, arg CreateReportParams) (int, error) {
+	db.reports[arg.PostID]++
+	return db.reports[arg.PostID], nil
+}
+
+func TestReportServiceReport(t *testing.T) {
+	ctx := context.Background()
+	posts := &reportPosts{posts: make(map[uuid.UUID]Post)}
+	for _, status := range append(PostModerationStatuses, PostStatusPending) {
+		id := uuid.New()
+		posts.posts[id] = Post{ID: id, Status: status}
+	}
+	reports := &reportDatabase{reports: make(map[uuid.UUID]int)}
+	rs := NewReportService(NewReportServiceArgs{
+		Adapter:             reports,
+		PostDatabaseService: NewPostDatabaseService(posts),
+		HideThreshold:       2,
+		RateLimit:           10,
+	})
+
+	for id, post := range posts.posts {
+		for i := 0; i < 2; i++ {
+			err := rs.Report(ctx, CreateReportParams{PostID: id, Reason: ReportReasonSpam})
+			if post.Status == PostStatusPublished && err != nil {
+				t.Fatalf("got %s reporting a published post", err)
+			}
+			if post.Status != PostStatusPublished && !errors.Is(err, ErrPostNotFound) {
+				t.Errorf("got %v reporting a %s post, want ErrPostNotFound", err, post.Status)
+			}
+		}
+		if post.Status != PostStatusPublished && reports.reports[id] > 0 {
+			t.Errorf("recorded %d reports of a %s post", reports.reports[id], post.Status)
+		}
+		if post.Status == PostStatusPublished && posts.posts[id].Status != PostStatusPendingReview {
+			t.Errorf("got %s post after 2 reports, want it hidden for review", posts.posts[id].Status)
+		}
+	}
+}
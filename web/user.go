@@ -0,0 +1,176 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var Roles = []Role{RoleUser, RoleModerator, RoleAdmin}
+
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r Role) Valid() bool {
+	return r.rank() >= 0
+}
+
+// Can reports whether r grants at least the permissions of required.
+func (r Role) Can(required Role) bool {
+	return r.Valid() && r.rank() >= required.rank()
+}
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidRole        = errors.New("invalid role")
+)
+
+// User is a staff account, anonymous visitors have no User.
+type User struct {
+	Name         string
+	Role         Role
+	PasswordHash string
+	CreatedAt    time.Time
+}
+
+// Ban is keyed by the IP address of the visitor, which unlike the visitor
+// cookie cannot be renewed at will.
+type Ban struct {
+	IP          string
+	Reason      string
+	ModeratorID string
+	CreatedAt   time.Time
+}
+
+type UserDatabaseAdapter interface {
+	UpsertUser(ctx context.Context, arg User) error
+	GetUser(ctx context.Context, name string) (User, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	DeleteUser(ctx context.Context, name string) error
+	CreateBan(ctx context.Context, arg Ban) error
+	DeleteBan(ctx context.Context, ip string) error
+	IsBanned(ctx context.Context, ip string) (bool, error)
+	ListBans(ctx context.Context) ([]Ban, error)
+}
+
+type UserService struct {
+	adapter             UserDatabaseAdapter
+	postDatabaseService *PostDatabaseService
+}
+
+func NewUserService(a UserDatabaseAdapter, pds *PostDatabaseService) *UserService {
+	return &UserService{
+		adapter:             a,
+		postDatabaseService: pds,
+	}
+}
+
+// SaveUser creates or updates a staff account.
+func (us *UserService) SaveUser(ctx context.Context, name, password string, role Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("cannot save user with role %q: %w", role, ErrInvalidRole)
+	}
+	if name == "" || password == "" {
+		return fmt.Errorf("cannot save user: %w", ErrInvalidCredentials)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("cannot hash user password: %w", err)
+	}
+	err = us.adapter.UpsertUser(ctx, User{
+		Name:         name,
+		Role:         role,
+		PasswordHash: string(hash),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot save user: %w", err)
+	}
+	return nil
+}
+
+func (us *UserService) Authenticate(ctx context.Context, name, password string) (User, error) {
+	user, err := us.adapter.GetUser(ctx, name)
+	if err == sql.ErrNoRows {
+		return User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("cannot get user: %w", err)
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	if err != nil {
+		return User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+func (us *UserService) ListUsers(ctx context.Context) ([]User, error) {
+	users, err := us.adapter.ListUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list users: %w", err)
+	}
+	return users, nil
+}
+
+func (us *UserService) DeleteUser(ctx context.Context, name string) error {
+	err := us.adapter.DeleteUser(ctx, name)
+	if err != nil {
+		return fmt.Errorf("cannot delete user: %w", err)
+	}
+	return nil
+}
+
+// Ban prevents the visitors of an IP address from voting, uploading and
+// reporting. When purgeVoter is set, the votes already cast by this visitor
+// are withdrawn.
+func (us *UserService) Ban(ctx context.Context, ban Ban, purgeVoter string) error {
+	err := us.adapter.CreateBan(ctx, ban)
+	if err != nil {
+		return fmt.Errorf("cannot ban visitor: %w", err)
+	}
+	if purgeVoter != "" {
+		return us.postDatabaseService.DeleteVoterVotes(ctx, purgeVoter)
+	}
+	return nil
+}
+
+func (us *UserService) Unban(ctx context.Context, ip string) error {
+	err := us.adapter.DeleteBan(ctx, ip)
+	if err != nil {
+		return fmt.Errorf("cannot unban visitor: %w", err)
+	}
+	return nil
+}
+
+func (us *UserService) IsBanned(ctx context.Context, ip string) (bool, error) {
+	banned, err := us.adapter.IsBanned(ctx, ip)
+	if err != nil {
+		return false, fmt.Errorf("cannot check visitor ban: %w", err)
+	}
+	return banned, nil
+}
+
+func (us *UserService) ListBans(ctx context.Context) ([]Ban, error) {
+	bans, err := us.adapter.ListBans(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list bans: %w", err)
+	}
+	return bans, nil
+}
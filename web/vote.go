@@ -0,0 +1,207 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+)
+
+type VoteMode string
+
+const (
+	// VoteModeSync writes every vote straight to the database.
+	VoteModeSync VoteMode = "sync"
+	// VoteModeWriteBehind buffers votes in the cache layer and flushes
+	// them to the database in batches.
+	VoteModeWriteBehind VoteMode = "write-behind"
+)
+
+const (
+	// applied batches are remembered long enough for a batch claimed before
+	// a crash to be handed back
+	voteBatchRetention     = 24 * time.Hour
+	voteBatchPruneInterval = time.Hour
+)
+
+var voteMetrics = expvar.NewMap("votes")
+
+type PostVoteService struct {
+	mode                VoteMode
+	postDatabaseService *PostDatabaseService
+	buffer              PostVoteBufferAdapter
+	maxBatchAttempts    int
+}
+
+type NewPostVoteServiceArgs struct {
+	Mode                VoteMode
+	PostDatabaseService *PostDatabaseService
+	Buffer              PostVoteBufferAdapter
+	// MaxBatchAttempts is how many times a batch is flushed before being
+	// set aside, so that a batch which cannot be applied does not block the
+	// next ones.
+	MaxBatchAttempts int
+}
+
+func NewPostVoteService(args NewPostVoteServiceArgs) (*PostVoteService, error) {
+	switch args.Mode {
+	case VoteModeSync:
+	case VoteModeWriteBehind:
+		if args.Buffer == nil {
+			return nil, fmt.Errorf("vote mode %s requires a vote buffer", args.Mode)
+		}
+		if args.MaxBatchAttempts < 1 {
+			return nil, fmt.Errorf("vote mode %s requires at least 1 batch attempt", args.Mode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown vote mode: %s", args.Mode)
+	}
+	return &PostVoteService{
+		mode:                args.Mode,
+		postDatabaseService: args.PostDatabaseService,
+		buffer:              args.Buffer,
+		maxBatchAttempts:    args.MaxBatchAttempts,
+	}, nil
+}
+
+func (pvs *PostVoteService) UpvotePost(ctx context.Context, args VotePostParams) error {
+	if pvs.mode == VoteModeSync {
+		return pvs.postDatabaseService.UpvotePost(ctx, args)
+	}
+	return pvs.bufferVote(ctx, args, 1)
+}
+
+func (pvs *PostVoteService) DownvotePost(ctx context.Context, args VotePostParams) error {
+	if pvs.mode == VoteModeSync {
+		return pvs.postDatabaseService.DownvotePost(ctx, args)
+	}
+	return pvs.bufferVote(ctx, args, -1)
+}
+
+func (pvs *PostVoteService) bufferVote(ctx context.Context, args VotePostParams, value int) error {
+	post, err := pvs.postDatabaseService.GetPost(ctx, args.ID)
+	if err != nil {
+		return err
+	}
+	if post.Status != PostStatusPublished {
+		return ErrPostNotFound
+	}
+	err = pvs.buffer.BufferVote(ctx, Vote{
+		PostID:    args.ID,
+		Voter:     args.Voter,
+		Value:     value,
+		CreatedAt: time.Now().UTC(),
+	})
+	if errors.Is(err, ErrCircuitOpen) {
+		// the buffer is down, do not lose the vote
+		if value > 0 {
+			return pvs.postDatabaseService.UpvotePost(ctx, args)
+		}
+		return pvs.postDatabaseService.DownvotePost(ctx, args)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot buffer vote: %w", err)
+	}
+	return nil
+}
+
+// MergePendingScores adds the buffered, not yet flushed, votes to the
+// scores read from the database.
+func (pvs *PostVoteService) MergePendingScores(ctx context.Context, posts []Post) []Post {
+	if pvs.mode == VoteModeSync {
+		return posts
+	}
+	deltas, err := pvs.buffer.PendingScores(ctx)
+	if errors.Is(err, ErrCircuitOpen) {
+		return posts
+	}
+	if err != nil {
+		log.Printf("[WARNING] failed to retreive pending votes: %s\n", err)
+		return posts
+	}
+	for i := range posts {
+		posts[i].Score += deltas[posts[i].ID]
+	}
+	return posts
+}
+
+// RunFlusher periodically persists buffered votes until ctx is done. A
+// batch claimed before a crash is handed back by the buffer and applied
+// again, the database ignoring batches it already applied.
+func (pvs *PostVoteService) RunFlusher(ctx context.Context, interval time.Duration) {
+	if pvs.mode == VoteModeSync {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var prunedAt time.Time
+
+	for {
+		if err := pvs.flush(ctx); err != nil && !errors.Is(err, ErrCircuitOpen) {
+			log.Printf("[WARNING] vote flusher: %s\n", err)
+		}
+		if time.Since(prunedAt) >= voteBatchPruneInterval {
+			prunedAt = time.Now()
+			if _, err := pvs.postDatabaseService.PruneVoteBatches(ctx, prunedAt.Add(-voteBatchRetention)); err != nil {
+				log.Printf("[WARNING] vote flusher: %s\n", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (pvs *PostVoteService) flush(ctx context.Context) error {
+	for {
+		batch, err := pvs.buffer.ClaimVoteBatch(ctx, pvs.maxBatchAttempts)
+		if err != nil {
+			return fmt.Errorf("cannot claim vote batch: %w", err)
+		}
+		if batch.DeadVotes > 0 {
+			voteMetrics.Add("dead_lettered", int64(batch.DeadVotes))
+			log.Printf("[WARNING] vote batch failed %d times, %d votes set aside as dead letters\n", pvs.maxBatchAttempts, batch.DeadVotes)
+		}
+		if batch.ID == "" {
+			return nil
+		}
+		if err := pvs.postDatabaseService.ApplyVoteBatch(ctx, batch); err != nil {
+			return err
+		}
+		if err := pvs.buffer.AckVoteBatch(ctx, batch.ID); err != nil {
+			return fmt.Errorf("cannot ack vote batch %s: %w", batch.ID, err)
+		}
+		voteMetrics.Add("flushed", int64(len(batch.Votes)))
+	}
+}
+
+// DeadVotes counts the buffered votes set aside after their batch failed to
+// flush too many times.
+func (pvs *PostVoteService) DeadVotes(ctx context.Context) (int64, error) {
+	if pvs.mode == VoteModeSync {
+		return 0, nil
+	}
+	n, err := pvs.buffer.DeadVotes(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot count dead votes: %w", err)
+	}
+	return n, nil
+}
+
+// ReplayDeadVotes buffers the dead votes again, once the cause of their
+// failure is fixed.
+func (pvs *PostVoteService) ReplayDeadVotes(ctx context.Context) (int64, error) {
+	if pvs.mode == VoteModeSync {
+		return 0, nil
+	}
+	n, err := pvs.buffer.ReplayDeadVotes(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot replay dead votes: %w", err)
+	}
+	voteMetrics.Add("replayed", n)
+	return n, nil
+}
@@ -0,0 +1,117 @@
+package web
+
+import (
+	"context"
+	"expvar"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type voteBuffer struct {
+	PostVoteBufferAdapter
+	batches []VoteBatch
+	acked   []string
+	dead    []Vote
+	pending []Vote
+}
+
+func (b *voteBuffer) ClaimVoteBatch(ctx context.Context, maxAttempts int) (VoteBatch, error) {
+	if len(b.batches) == 0 {
+		return VoteBatch{}, nil
+	}
+	batch := b.batches[0]
+	b.batches = b.batches[1:]
+	return batch, nil
+}
+
+func (b *voteBuffer) AckVoteBatch(ctx context.Context, batchID string) error {
+	b.acked = append(b.acked, batchID)
+	return nil
+}
+
+func (b *voteBuffer) DeadVotes(ctx context.Context) (int64, error) {
+	return int64(len(b.dead)), nil
+}
+
+func (b *voteBuffer) ReplayDeadVotes(ctx context.Context) (int64, error) {
+	n := int64(len(b.dead))
+	b.pending = append(b.pending, b.dead...)
+	b.dead = nil
+	return n, nil
+}
+
+type voteDatabase struct {
+	PostDatabaseAdapter
+	applied []string
+}
+
+func (db *voteDatabase) ApplyVoteBatch(ctx context.Context, batch VoteBatch) error {
+	db.applied = append(db.applied, batch.ID)
+	return nil
+}
+
+func voteMetric(name string) int64 {
+	if v, ok := voteMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestPostVoteServiceFlush(t *testing.T) {
+	ctx := context.Background()
+	vote := Vote{PostID: uuid.New(), Voter: "visitor", Value: 1}
+	buffer := &voteBuffer{
+		batches: []VoteBatch{
+			{ID: "b1", Votes: []Vote{vote}, DeadVotes: 2},
+			{DeadVotes: 1},
+		},
+		dead: []Vote{vote, vote, vote},
+	}
+	db := &voteDatabase{}
+	pvs, err := NewPostVoteService(NewPostVoteServiceArgs{
+		Mode:                VoteModeWriteBehind,
+		PostDatabaseService: NewPostDatabaseService(db),
+		Buffer:              buffer,
+		MaxBatchAttempts:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadLettered := voteMetric("dead_lettered")
+	if err := pvs.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.applied) != 1 || len(buffer.acked) != 1 || buffer.acked[0] != "b1" {
+		t.Errorf("applied %v and acked %v, want b1", db.applied, buffer.acked)
+	}
+	if got := voteMetric("dead_lettered") - deadLettered; got != 3 {
+		t.Errorf("dead_lettered grew by %d, want 3", got)
+	}
+
+	n, err := pvs.DeadVotes(ctx)
+	if err != nil || n != 3 {
+		t.Errorf("DeadVotes() = %d, %v, want 3", n, err)
+	}
+	n, err = pvs.ReplayDeadVotes(ctx)
+	if err != nil || n != 3 || len(buffer.pending) != 3 {
+		t.Errorf("ReplayDeadVotes() = %d, %v, with %d pending, want 3", n, err, len(buffer.pending))
+	}
+	if n, _ := pvs.DeadVotes(ctx); n != 0 {
+		t.Errorf("DeadVotes() = %d after replay, want 0", n)
+	}
+}
+
+func TestPostVoteServiceDeadVotesSync(t *testing.T) {
+	pvs, err := NewPostVoteService(NewPostVoteServiceArgs{Mode: VoteModeSync})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := pvs.DeadVotes(context.Background()); n != 0 || err != nil {
+		t.Errorf("DeadVotes() = %d, %v, want 0", n, err)
+	}
+	if n, err := pvs.ReplayDeadVotes(context.Background()); n != 0 || err != nil {
+		t.Errorf("ReplayDeadVotes() = %d, %v, want 0", n, err)
+	}
+}
@@ -0,0 +1,97 @@
+package web
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sort"
+	"time"
+)
+
+var warmerMetrics = expvar.NewMap("cache_warmer")
+
+// PostCacheWarmer queues the render of posts ahead of their first visitor:
+// new posts right after upload, and the top posts by score shortly before
+// their cache entry expires.
+type PostCacheWarmer struct {
+	postDatabaseService *PostDatabaseService
+	postCacheService    *PostCacheService
+	postRenderService   *PostRenderService
+	interval            time.Duration
+	topN                int
+	refreshBefore       time.Duration
+}
+
+type NewPostCacheWarmerArgs struct {
+	PostDatabaseService *PostDatabaseService
+	PostCacheService    *PostCacheService
+	PostRenderService   *PostRenderService
+	Interval            time.Duration
+	// TopN posts are kept warm, 0 only warms new posts.
+	TopN int
+	// RefreshBefore is how long before expiry a top post is rendered again,
+	// it should exceed Interval.
+	RefreshBefore time.Duration
+}
+
+func NewPostCacheWarmer(args NewPostCacheWarmerArgs) *PostCacheWarmer {
+	return &PostCacheWarmer{
+		postDatabaseService: args.PostDatabaseService,
+		postCacheService:    args.PostCacheService,
+		postRenderService:   args.PostRenderService,
+		interval:            args.Interval,
+		topN:                args.TopN,
+		refreshBefore:       args.RefreshBefore,
+	}
+}
+
+// Warm queues the render of a post with the default options.
+func (w *PostCacheWarmer) Warm(ctx context.Context, post Post) {
+	err := w.postRenderService.Enqueue(ctx, post, w.postRenderService.DefaultOptions())
+	if err != nil {
+		warmerMetrics.Add("failed", 1)
+		log.Printf("[WARNING] failed to warm post %s: %s\n", post.ID, err)
+		return
+	}
+	warmerMetrics.Add("queued", 1)
+}
+
+// Run queues the top posts every interval until ctx is done.
+func (w *PostCacheWarmer) Run(ctx context.Context) {
+	if w.topN <= 0 {
+		return
+	}
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.warmTopPosts(ctx)
+		}
+	}
+}
+
+// warmTopPosts queues the top posts missing from the cache or expiring
+// within refreshBefore.
+func (w *PostCacheWarmer) warmTopPosts(ctx context.Context) {
+	posts, err := w.postDatabaseService.ListPosts(ctx)
+	if err != nil {
+		log.Printf("[WARNING] cache warmer failed to list posts: %s\n", err)
+		return
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].Score > posts[j].Score
+	})
+	if len(posts) > w.topN {
+		posts = posts[:w.topN]
+	}
+	for _, post := range posts {
+		_, expiresAt, err := w.postCacheService.GetHTML(ctx, post.ID, "")
+		if err == nil && (expiresAt.IsZero() || time.Until(expiresAt) > w.refreshBefore) {
+			continue
+		}
+		w.Warm(ctx, post)
+	}
+}
@@ -0,0 +1,71 @@
+package web_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skale-5/skalogram/render"
+	"github.com/skale-5/skalogram/web"
+	"github.com/skale-5/skalogram/web/pkg/memory"
+)
+
+type warmerPosts struct {
+	web.PostDatabaseAdapter
+	posts []web.Post
+}
+
+func (db *warmerPosts) ListPosts(ctx context.Context) ([]web.Post, error) {
+	return db.posts, nil
+}
+
+func TestPostCacheWarmerTopPosts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := web.NewPostCacheService(memory.NewClient(10, 0))
+	queue := memory.NewRenderQueue(10)
+	db := &warmerPosts{}
+	ttls := map[string]time.Duration{
+		"fresh":    time.Hour,
+		"expiring": 10 * time.Second,
+		"missing":  -1,
+		"eternal":  0,
+	}
+	names := make(map[uuid.UUID]string)
+	for name, ttl := range ttls {
+		post := web.Post{ID: uuid.New(), Status: web.PostStatusPublished}
+		names[post.ID] = name
+		db.posts = append(db.posts, post)
+		if ttl >= 0 {
+			cache.CacheHTML(ctx, post.ID, "", "@", ttl)
+		}
+	}
+	w := web.NewPostCacheWarmer(web.NewPostCacheWarmerArgs{
+		PostDatabaseService: web.NewPostDatabaseService(db),
+		PostCacheService:    cache,
+		PostRenderService: web.NewPostRenderService(web.NewPostRenderServiceArgs{
+			PostCacheService: cache,
+			Queue:            queue,
+			QueuePendingTTL:  time.Minute,
+			QueueMaxLength:   10,
+			Defaults:         render.DefaultOptions(),
+		}),
+		Interval:      10 * time.Millisecond,
+		TopN:          len(ttls),
+		RefreshBefore: time.Minute,
+	})
+	go w.Run(ctx)
+
+	warmed := make(map[string]bool)
+	for len(warmed) < 2 {
+		job, err := queue.DequeueRender(ctx)
+		if err != nil {
+			t.Fatalf("got %v, want the expiring and missing posts queued", err)
+		}
+		warmed[names[job.PostID]] = true
+	}
+	if !warmed["expiring"] || !warmed["missing"] {
+		t.Errorf("got %v warmed, want the expiring and missing posts", warmed)
+	}
+}